@@ -1,13 +1,19 @@
 package main
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	histo "github.com/HdrHistogram/hdrhistogram-go"
@@ -17,7 +23,7 @@ import (
 
 const APP_VERSION = "0.10"
 
-//default that can be overridden from the command line
+// default that can be overridden from the command line
 var versionFlag bool = false
 var helpFlag bool = false
 var duration int = 10 //seconds
@@ -40,6 +46,52 @@ var clientKey string
 var caCert string
 var http2 bool
 var cpus int = 0
+var graphqlFile string
+var graphqlVars string
+var graphqlOp string
+var proto string
+var h2Cfg loader.H2Cfg
+var h2ReadIdlems int
+var h2PingTimeoutms int
+var wsHello string
+var wsMessage string
+var wsRate float64
+var wsOrigin string
+var streamMode string
+var streamTsField string
+var grpcMethod string
+var grpcData string
+var grpcJson string
+var grpcDescriptors string
+var sockPayload string
+var sockRespLen int
+var sockDelim string
+var unixUrl string
+var pipeline int
+var proxyUrl string
+var proxyBypass string
+var tlsMin string
+var tlsMax string
+var tlsCiphers string
+var tlsCurves string
+var tlsServerName string
+var tlsAlpn string
+var tlsResume bool
+var resolveFlags util.HeaderList
+var dnsServer string
+var dnsMode string
+var bindAddrs string
+var bindPorts string
+var engine string
+var bodyMode string
+var bodyPrefix int64
+var expectContinuems int
+var successCodes string
+var assertFlags util.HeaderList
+var assertSample int
+var intervalms int
+var label string
+var labelSort string
 
 func init() {
 	flag.BoolVar(&versionFlag, "v", false, "Print version details")
@@ -60,10 +112,61 @@ func init() {
 	flag.StringVar(&clientCert, "cert", "", "CA certificate file to verify peer against (SSL/TLS)")
 	flag.StringVar(&clientKey, "key", "", "Private key file name (SSL/TLS")
 	flag.StringVar(&caCert, "ca", "", "CA file to verify peer against (SSL/TLS)")
-	flag.BoolVar(&http2, "http", true, "Use HTTP/2")
+	flag.BoolVar(&http2, "http", true, "Use HTTP/2 when negotiated by TLS (-proto auto)")
+	flag.StringVar(&proto, "proto", loader.PROTO_AUTO, "HTTP protocol: h1, h2 (over TLS), h2c (cleartext, prior knowledge) or auto")
+	flag.IntVar(&h2Cfg.Conns, "h2-conns", 0, "Number of HTTP/2 connections shared by the goroutines, each carries c/h2-conns streams. 0 = a connection per goroutine")
+	flag.BoolVar(&h2Cfg.StrictStreams, "h2-strict", false, "Never exceed the server's max concurrent streams per HTTP/2 connection, queue the requests instead")
+	flag.Func("h2-frame", "HTTP/2 max frame size to read (Default 16384)", func(v string) error { return parseUint32(v, &h2Cfg.MaxFrameSize) })
+	flag.Func("h2-hpack", "HTTP/2 HPACK header table size (Default 4096)", func(v string) error { return parseUint32(v, &h2Cfg.HeaderTableSize) })
+	flag.IntVar(&h2Cfg.ConnWindow, "h2-conn-window", 0, "HTTP/2 initial connection flow control window in bytes. 0 = default")
+	flag.IntVar(&h2Cfg.StreamWindow, "h2-stream-window", 0, "HTTP/2 initial stream flow control window in bytes. 0 = default")
+	flag.IntVar(&h2ReadIdlems, "h2-read-idle", 0, "Send an HTTP/2 ping after this many ms without a frame. 0 = never")
+	flag.StringVar(&wsHello, "ws-hello", "", "WebSocket message string or @filename sent once after connecting")
+	flag.StringVar(&wsMessage, "ws-msg", loader.DEFAULT_WS_MESSAGE, "WebSocket message string or @filename, a text/template where .ID is a correlation id expected in the reply")
+	flag.Float64Var(&wsRate, "ws-rate", 0, "WebSocket messages per second per connection. 0 = send each message after the reply to the previous one")
+	flag.StringVar(&wsOrigin, "ws-origin", "", "WebSocket Origin header. Empty = the target url with an http(s) scheme")
+	flag.StringVar(&streamMode, "stream", "", "Streaming mode: sse (Server-Sent Events) or longpoll. Empty = plain requests")
+	flag.StringVar(&streamTsField, "stream-ts", "", "JSON field of the events holding their publish time (unix ms or RFC 3339), to measure the delivery delay")
+	flag.StringVar(&grpcMethod, "grpc", "", "gRPC mode - the package.Service/Method to call")
+	flag.StringVar(&grpcData, "grpc-data", "", "Comma separated files of serialized protobuf request messages, several files make a client stream")
+	flag.StringVar(&grpcJson, "grpc-json", "", "gRPC request message as a JSON string or @filename, a JSON array makes a client stream. Requires -grpc-desc")
+	flag.StringVar(&grpcDescriptors, "grpc-desc", "", "FileDescriptorSet file (protoc --include_imports --descriptor_set_out) to convert -grpc-json")
+	flag.StringVar(&sockPayload, "sock-payload", "", "tcp:// and udp:// payload as a hex string (a text/template with .Seq) or @filename of the raw bytes")
+	flag.IntVar(&sockRespLen, "sock-resp-len", 0, "tcp:// and udp:// reply length in bytes. 0 = a single read, unless -sock-delim is set")
+	flag.StringVar(&sockDelim, "sock-delim", "", "tcp:// and udp:// hex delimiter that ends a reply, e.g. 0d0a")
+	flag.StringVar(&unixUrl, "unix-url", loader.DEFAULT_UNIX_URL, "HTTP url (path, query and Host) requested over a unix:///path/to.sock target")
+	flag.IntVar(&pipeline, "pipeline", 0, "Pipeline depth - HTTP/1.1 requests written on a connection before reading their responses. 0 = no pipelining")
+	flag.StringVar(&proxyUrl, "proxy", "", "Outbound proxy url: http://, https:// or socks5://, with optional user:password@")
+	flag.StringVar(&proxyBypass, "proxy-bypass", "", "Comma separated hosts, domains, IPs and CIDRs (optionally :port) connected to directly instead of through -proxy")
+	flag.StringVar(&tlsMin, "tls-min", "", "Minimum TLS version: 1.0, 1.1, 1.2 or 1.3. Empty = Go default")
+	flag.StringVar(&tlsMax, "tls-max", "", "Maximum TLS version: 1.0, 1.1, 1.2 or 1.3. Empty = Go default")
+	flag.StringVar(&tlsCiphers, "tls-ciphers", "", "Comma separated TLS 1.0-1.2 cipher suites, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. Empty = Go default")
+	flag.StringVar(&tlsCurves, "tls-curves", "", "Comma separated key exchange curves in order of preference, e.g. X25519,P-256. Empty = Go default")
+	flag.StringVar(&tlsServerName, "sni", "", "TLS server name (SNI) to send and verify. Empty = the url host")
+	flag.StringVar(&tlsAlpn, "alpn", "", "Comma separated ALPN protocols to offer. Empty = chosen by -proto/-http")
+	flag.BoolVar(&tlsResume, "tls-resume", false, "Resume TLS sessions (session tickets) across connections, instead of a full handshake per connection")
+	flag.Var(&resolveFlags, "resolve", "Connect to addr instead of resolving host:port, curl style host:port:addr[,addr...] (you can define multiple -resolve flags)")
+	flag.StringVar(&dnsServer, "dns-server", "", "DNS server ip[:port] to resolve the target with. Empty = the system resolver")
+	flag.StringVar(&dnsMode, "dns-mode", "", "When to resolve: once (for the whole test), conn (every new connection) or ttl (when the records expire). Empty = Go default")
+	flag.StringVar(&bindAddrs, "bind", "", "Comma separated local IPs and CIDRs the connections are made from, in turn. Empty = chosen by the OS")
+	flag.StringVar(&bindPorts, "bind-ports", "", "Local port range lo-hi the connections are made from. Empty = ephemeral ports")
+	flag.StringVar(&engine, "engine", loader.ENGINE_NET, "Request engine: net (net/http), raw (HTTP/1.1 serialized once, headers sent in the given order and case) or epoll (raw on an event loop per CPU, Linux only)")
+	flag.StringVar(&bodyMode, "body-mode", loader.BODY_DISCARD, "Response body handling: discard (read into pooled buffers), headers (stop after the headers), prefix (read -body-prefix bytes) or full (buffer it)")
+	flag.Int64Var(&bodyPrefix, "body-prefix", 1024, "Bytes of the body read by -body-mode prefix")
+	flag.Var(&assertFlags, "assert", "Check the successful responses, kind:arg with kind contains, regex, json (path[=value]), header (name[=value]), size (min-max) or golden (file) (you can define multiple -assert flags)")
+	flag.IntVar(&assertSample, "assert-sample", 1, "Check one response in this many with -assert")
+	flag.StringVar(&label, "label", "", "Label of all the requests in the per-label statistics. Empty = a label per request: the GraphQL operation, the gRPC method or the method and path template, e.g. GET /users/{id}")
+	flag.StringVar(&labelSort, "label-sort", "label", "Column the per-label statistics are sorted by: label, requests, rps, errors, err%, avg, p50, p90, p99 or max")
+	flag.IntVar(&intervalms, "interval", 0, "Keep statistics for every interval of this many ms, printed as a table at the end. 0 = off")
+	flag.StringVar(&successCodes, "success", loader.DEFAULT_SUCCESS, "Status codes counted as successful, e.g. \"2xx,304\" or \"200-204\"; the others are errors")
+	flag.IntVar(&expectContinuems, "expect-continue", 0, "Send the body after an Expect: 100-continue handshake, waiting up to this many ms for the server's 100. 0 = off")
+	flag.IntVar(&h2PingTimeoutms, "h2-ping-timeout", 0, "Close an HTTP/2 connection when a ping is not answered within this many ms. 0 = default (15s)")
+	flag.StringVar(&graphqlFile, "gql", "", "GraphQL mode - query document file name, sent as a JSON POST")
+	flag.StringVar(&graphqlVars, "gql-vars", "", "GraphQL variables string or @filename (a text/template with .Seq, .Unix and randInt)")
+	flag.StringVar(&graphqlOp, "gql-op", "", "GraphQL operation name. Empty cycles through all the operations in the document")
 }
 
-//printDefaults a nicer format for the defaults
+// printDefaults a nicer format for the defaults
 func printDefaults() {
 	fmt.Println("Usage: go-wrk <options> <url>")
 	fmt.Println("Options:")
@@ -73,11 +176,11 @@ func printDefaults() {
 }
 
 func mapToString(m map[string]int) string {
-	s := make([]string,0,len(m))
-	for k,v := range m {
-		s = append(s,fmt.Sprint(k,"=",v))
+	s := make([]string, 0, len(m))
+	for k, v := range m {
+		s = append(s, fmt.Sprint(k, "=", v))
 	}
-	return strings.Join(s,",")
+	return strings.Join(s, ",")
 }
 
 func main() {
@@ -127,45 +230,231 @@ func main() {
 
 	fmt.Printf("Running %vs test @ %v\n  %v goroutine(s) running concurrently\n", duration, testUrl, goroutines)
 
-	if len(reqBody) > 0 && reqBody[0] == '@' {
-		bodyFilename := reqBody[1:]
-		data, err := ioutil.ReadFile(bodyFilename)
+	var err error
+	reqBody, err = readArg(reqBody)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	if err = loader.ValidProto(proto); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	h2Cfg.ReadIdleTimeout = time.Duration(h2ReadIdlems) * time.Millisecond
+	h2Cfg.PingTimeout = time.Duration(h2PingTimeoutms) * time.Millisecond
+	opts := []loader.Option{loader.WithProto(proto), loader.WithHTTP2(h2Cfg), loader.WithUnixUrl(unixUrl), loader.WithPipeline(pipeline)}
+	if pipeline > 1 && (graphqlFile != "" || grpcMethod != "" || streamMode != "") {
+		fmt.Println("-pipeline only supports plain HTTP requests")
+		os.Exit(1)
+	}
+	body, err := loader.NewBodyCfg(bodyMode, bodyPrefix)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if body.Mode() != loader.BODY_DISCARD && (engine != loader.ENGINE_NET || pipeline > 1) {
+		fmt.Println("-body-mode is only available with -engine net, without -pipeline")
+		os.Exit(1)
+	}
+	opts = append(opts, loader.WithBody(body))
+	if expectContinuems > 0 {
+		if reqBody == "" || engine != loader.ENGINE_NET {
+			fmt.Println("-expect-continue requires a -body, with -engine net")
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithExpectContinue(time.Duration(expectContinuems)*time.Millisecond))
+	}
+	success, err := loader.NewSuccessCfg(successCodes)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	opts = append(opts, loader.WithSuccess(success))
+	if intervalms > 0 {
+		opts = append(opts, loader.WithInterval(time.Duration(intervalms)*time.Millisecond))
+	}
+	if label != "" {
+		opts = append(opts, loader.WithLabel(label))
+	}
+	if _, ok := labelColumns[labelSort]; !ok {
+		fmt.Println("unknown -label-sort column", labelSort)
+		os.Exit(1)
+	}
+	if len(assertFlags) > 0 {
+		if engine != loader.ENGINE_NET || pipeline > 1 || graphqlFile != "" || grpcMethod != "" || streamMode != "" {
+			fmt.Println("-assert is only available for plain requests with -engine net, without -pipeline")
+			os.Exit(1)
+		}
+		assert, err := loader.NewAssertCfg(assertFlags, assertSample)
 		if err != nil {
-			fmt.Println(fmt.Errorf("could not read file %q: %v", bodyFilename, err))
+			fmt.Println(err)
 			os.Exit(1)
 		}
-		reqBody = string(data)
+		opts = append(opts, loader.WithAssert(assert))
+	}
+	if err = loader.ValidEngine(engine); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if engine == loader.ENGINE_RAW || engine == loader.ENGINE_EPOLL {
+		if graphqlFile != "" || grpcMethod != "" || streamMode != "" || pipeline > 1 || proxyUrl != "" ||
+			proto == loader.PROTO_H2 || proto == loader.PROTO_H2C {
+			fmt.Println("-engine", engine, "only supports plain HTTP/1.1 requests")
+			os.Exit(1)
+		}
+		raw, err := loader.NewRawCfg(headerFlags)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithRaw(raw))
+		if engine == loader.ENGINE_EPOLL {
+			opts = append(opts, loader.WithEpoll(0))
+		}
+	}
+	if graphqlFile != "" {
+		query, err := ioutil.ReadFile(graphqlFile)
+		if err != nil {
+			fmt.Println(fmt.Errorf("could not read file %q: %v", graphqlFile, err))
+			os.Exit(1)
+		}
+		vars, err := readArg(graphqlVars)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		gql, err := loader.NewGraphQLCfg(string(query), vars, graphqlOp)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithGraphQL(gql))
+	}
+
+	if loader.IsWebSocketUrl(testUrl) {
+		hello, err := readArg(wsHello)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		msg, err := readArg(wsMessage)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ws, err := loader.NewWSCfg(hello, msg, wsRate, wsOrigin)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithWebSocket(ws))
+	}
+
+	// SSLKEYLOGFILE is the environment variable browsers and curl use, for decrypting captures in Wireshark
+	tlsCfg, err := loader.NewTLSCfg(tlsMin, tlsMax, tlsCiphers, tlsCurves, tlsServerName, tlsAlpn, tlsResume, os.Getenv("SSLKEYLOGFILE"))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	opts = append(opts, loader.WithTLS(tlsCfg))
+
+	if len(resolveFlags) > 0 || dnsServer != "" || dnsMode != "" {
+		resolve, err := loader.NewResolveCfg(resolveFlags, dnsServer, dnsMode)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithResolve(resolve))
+	}
+
+	if bindAddrs != "" || bindPorts != "" {
+		bind, err := loader.NewBindCfg(bindAddrs, bindPorts)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithBind(bind))
+	}
+
+	if proxyUrl != "" {
+		if strings.HasPrefix(testUrl, "udp://") {
+			fmt.Println("-proxy only tunnels TCP connections, udp:// targets are not supported")
+			os.Exit(1)
+		}
+		p, err := loader.NewProxyCfg(proxyUrl, proxyBypass)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithProxy(p))
+	}
+
+	if loader.IsSocketUrl(testUrl) {
+		payload := sockPayload
+		if strings.HasPrefix(payload, "@") {
+			data, err := ioutil.ReadFile(payload[1:])
+			if err != nil {
+				fmt.Println(fmt.Errorf("could not read file %q: %v", payload[1:], err))
+				os.Exit(1)
+			}
+			payload = hex.EncodeToString(data)
+		}
+		sock, err := loader.NewSocketCfg(payload, sockRespLen, sockDelim)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithSocket(sock))
+	}
+
+	if streamMode != "" {
+		stream, err := loader.NewStreamCfg(streamMode, streamTsField)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithStream(stream))
+	}
+
+	if grpcMethod != "" {
+		messages, err := grpcMessages()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		call, err := loader.NewGRPCCfg(grpcMethod, messages)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, loader.WithGRPC(call))
 	}
 
 	loadGen := loader.NewLoadCfg(duration, goroutines, testUrl, reqBody, method, host, header, statsAggregator, timeoutms,
-		allowRedirectsFlag, disableCompression, disableKeepAlive, skipVerify, clientCert, clientKey, caCert, http2)
+		allowRedirectsFlag, disableCompression, disableKeepAlive, skipVerify, clientCert, clientKey, caCert, http2, opts...)
 
 	start := time.Now()
 
-	for i := 0; i < goroutines; i++ {
+	sessions := loadGen.Sessions()
+	for i := 0; i < sessions; i++ {
 		go loadGen.RunSingleLoadSession()
 	}
 
 	responders := 0
-	aggStats := loader.RequesterStats{ErrMap: make(map[string]int), Histogram: histo.New(1,int64(duration * 1000000),4)}
+	aggStats := loader.NewRequesterStats(duration)
 
-	for responders < goroutines {
+	for responders < sessions {
 		select {
 		case <-sigChan:
 			loadGen.Stop()
 			fmt.Printf("stopping...\n")
 		case stats := <-statsAggregator:
-			aggStats.NumErrs += stats.NumErrs
-			aggStats.NumRequests += stats.NumRequests
-			aggStats.TotRespSize += stats.TotRespSize
-			aggStats.TotDuration += stats.TotDuration
+			aggStats.Merge(stats)
 			responders++
-			for k,v := range stats.ErrMap {
-				aggStats.ErrMap[k] += v
-			}
-			aggStats.Histogram.Merge(stats.Histogram)
 		}
 	}
+	loadGen.Close()
 
 	duration := time.Now().Sub(start)
 
@@ -173,8 +462,11 @@ func main() {
 		fmt.Println("Error: No statistics collected / no requests found")
 		fmt.Printf("Number of Errors:\t%v\n", aggStats.NumErrs)
 		if aggStats.NumErrs > 0 {
-			fmt.Printf("Error Counts:\t\t%v\n", mapToString(aggStats.ErrMap))
+			printErrors(aggStats, start)
+			printPortExhaustionHint(aggStats)
 		}
+		printStatusCodes(aggStats)
+		printAssertions(aggStats.Assertions)
 		return
 	}
 
@@ -186,15 +478,28 @@ func main() {
 	overallReqRate := float64(aggStats.NumRequests) / duration.Seconds()
 	overallBytesRate := float64(aggStats.TotRespSize) / duration.Seconds()
 
-	fmt.Printf("%v requests in %v, %v read\n", aggStats.NumRequests, avgThreadDur, util.ByteSize{float64(aggStats.TotRespSize)})
-	fmt.Printf("Requests/sec:\t\t%.2f\nTransfer/sec:\t\t%v\n", reqRate, util.ByteSize{bytesRate})
-	fmt.Printf("Overall Requests/sec:\t%.2f\nOverall Transfer/sec:\t%v\n", overallReqRate, util.ByteSize{overallBytesRate})
+	fmt.Printf("%v requests in %v, %v read\n", aggStats.NumRequests, avgThreadDur, util.ByteSize{Size: float64(aggStats.TotRespSize)})
+	fmt.Printf("Requests/sec:\t\t%.2f\nTransfer/sec:\t\t%v\n", reqRate, util.ByteSize{Size: bytesRate})
+	fmt.Printf("Overall Requests/sec:\t%.2f\nOverall Transfer/sec:\t%v\n", overallReqRate, util.ByteSize{Size: overallBytesRate})
+	if aggStats.Wire != nil {
+		printBytes("Sent:", aggStats.TotReqSize, aggStats.Wire.Sent(), duration)
+		printBytes("Received:", aggStats.TotRespSize, aggStats.Wire.Received(), duration)
+	}
 	fmt.Printf("Fastest Request:\t%v\n", toDuration(aggStats.Histogram.Min()))
 	fmt.Printf("Avg Req Time:\t\t%v\n", toDuration(int64(aggStats.Histogram.Mean())))
 	fmt.Printf("Slowest Request:\t%v\n", toDuration(aggStats.Histogram.Max()))
 	fmt.Printf("Number of Errors:\t%v\n", aggStats.NumErrs)
 	if aggStats.NumErrs > 0 {
-		fmt.Printf("Error Counts:\t\t%v\n", mapToString(aggStats.ErrMap))
+		printErrors(aggStats, start)
+		printPortExhaustionHint(aggStats)
+	}
+	fmt.Printf("Protocols:\t\t%v\n", mapToString(aggStats.Protocols))
+	if aggStats.H2Conns > 0 {
+		fmt.Printf("HTTP/2 Connections:\t%v (%.1f streams per connection)\n", aggStats.H2Conns,
+			float64(aggStats.Protocols["HTTP/2.0"])/float64(aggStats.H2Conns))
+	}
+	if len(aggStats.H2Errors) > 0 {
+		fmt.Printf("HTTP/2 Errors:\t\t%v\n", mapToString(aggStats.H2Errors))
 	}
 	fmt.Printf("10%%:\t\t\t%v\n", toDuration(aggStats.Histogram.ValueAtPercentile(.10)))
 	fmt.Printf("50%%:\t\t\t%v\n", toDuration(aggStats.Histogram.ValueAtPercentile(.50)))
@@ -204,9 +509,381 @@ func main() {
 	fmt.Printf("99.9999%%:\t\t%v\n", toDuration(aggStats.Histogram.ValueAtPercentile(.999999)))
 	fmt.Printf("99.99999%%:\t\t%v\n", toDuration(aggStats.Histogram.ValueAtPercentile(.9999999)))
 	fmt.Printf("stddev:\t\t\t%v\n", toDuration(int64(aggStats.Histogram.StdDev())))
+	printLabels(aggStats.Labels, avgThreadDur, labelSort)
+	printStatusCodes(aggStats)
+	printAssertions(aggStats.Assertions)
+	if aggStats.Intervals != nil {
+		printIntervals(aggStats.Intervals)
+	}
+	if aggStats.Phases != nil {
+		printPhases(aggStats.Phases)
+	}
+	if aggStats.Conns != nil {
+		printConns(aggStats.Conns.Stats())
+	}
+	if aggStats.WebSocket != nil {
+		printWebSocket(aggStats.WebSocket, duration)
+	}
+	if aggStats.Stream != nil {
+		printStream(aggStats.Stream, duration)
+	}
+	if aggStats.DNS != nil {
+		fmt.Printf("DNS Lookups:\t\t%v\n", aggStats.DNS.Lookups)
+		printHistogramLine("DNS Lookup Time:", aggStats.DNS.LookupHist)
+		if len(aggStats.DNS.Addrs) > 0 {
+			fmt.Printf("Connections by Address:\t%v\n", mapToString(aggStats.DNS.Addrs))
+		}
+	}
+	if aggStats.TLS != nil {
+		printTLS(aggStats.TLS)
+	}
+	if c := aggStats.Continue; c != nil {
+		fmt.Printf("100-continue:\t\t%v requests, %v continued, %v rejected before the body, %v sent after the wait\n",
+			c.Requests, c.Continued, c.Rejected, c.Expired)
+		printHistogramLine("100-continue Wait:", c.WaitHist)
+	}
+	if aggStats.Proxy != nil {
+		fmt.Printf("Proxy Connections:\t%v\n", aggStats.Proxy.Connects)
+		printHistogramLine("Proxy Connect Time:", aggStats.Proxy.ConnectHist)
+	}
+	if aggStats.Pipeline != nil {
+		p := aggStats.Pipeline
+		fmt.Printf("Pipeline Depth:\t\t%v (%v batches on %v connections, %v failed, %v unanswered requests)\n", p.Depth,
+			p.Batches, p.Connects, p.ConnectErrs, p.Unanswered)
+	}
+	if aggStats.Socket != nil {
+		printSocket(aggStats.Socket, duration)
+	}
+	if aggStats.GRPC != nil {
+		fmt.Printf("gRPC Status:\t\t%v\n", mapToString(aggStats.GRPC.Status))
+		fmt.Printf("gRPC Messages:\t\t%v sent, %v received\n", aggStats.GRPC.MsgsSent, aggStats.GRPC.MsgsRecv)
+	}
+	if len(aggStats.Operations) > 0 {
+		printGroups("Operation", aggStats.Operations)
+	}
 	// aggStats.Histogram.PercentilesPrint(os.Stdout,1,1)
 }
 
+// printErrors the error counts by category, the most frequent first, with when the first one happened and examples of
+// their messages
+func printErrors(stats *loader.RequesterStats, start time.Time) {
+	categories := make([]string, 0, len(stats.Errors))
+	for c := range stats.Errors {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		a, b := stats.Errors[categories[i]], stats.Errors[categories[j]]
+		return a.Count > b.Count || a.Count == b.Count && categories[i] < categories[j]
+	})
+	counts := make([]string, 0, len(categories))
+	for _, c := range categories {
+		counts = append(counts, fmt.Sprint(c, "=", stats.Errors[c].Count))
+	}
+	fmt.Printf("Error Counts:\t\t%v\n", strings.Join(counts, ","))
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Error\tCount\tFirst\tExamples\n")
+	for _, c := range categories {
+		e := stats.Errors[c]
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", c, e.Count, e.First.Sub(start).Round(time.Millisecond),
+			strings.Join(e.Examples, " | "))
+	}
+	w.Flush()
+}
+
+// printPortExhaustionHint explains how to get more local ports, when connections failed for the lack of them
+func printPortExhaustionHint(stats *loader.RequesterStats) {
+	if stats.Errors[loader.ERR_PORT_EXHAUSTION] == nil {
+		return
+	}
+	fmt.Println("Hint:\t\t\tthe local ports ran out - keep connections alive (no -no-ka), spread the connections over")
+	fmt.Println("\t\t\tmore source addresses with -bind, widen net.ipv4.ip_local_port_range or enable net.ipv4.tcp_tw_reuse")
+}
+
+func parseUint32(v string, out *uint32) error {
+	n, err := strconv.ParseUint(v, 10, 32)
+	*out = uint32(n)
+	return err
+}
+
+// grpcMessages the serialized request messages, from -grpc-data files or converted from -grpc-json
+func grpcMessages() ([][]byte, error) {
+	var messages [][]byte
+	if grpcData != "" {
+		for _, filename := range strings.Split(grpcData, ",") {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, fmt.Errorf("could not read file %q: %v", filename, err)
+			}
+			messages = append(messages, data)
+		}
+	}
+	if grpcJson == "" {
+		return messages, nil
+	}
+	if grpcDescriptors == "" {
+		return nil, fmt.Errorf("-grpc-json requires a descriptor set (-grpc-desc)")
+	}
+	set, err := ioutil.ReadFile(grpcDescriptors)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file %q: %v", grpcDescriptors, err)
+	}
+	desc, err := loader.ParseProtoDescriptors(set)
+	if err != nil {
+		return nil, err
+	}
+	input, err := desc.InputType(strings.TrimPrefix(grpcMethod, "/"))
+	if err != nil {
+		return nil, err
+	}
+	jsonMsgs, err := readArg(grpcJson)
+	if err != nil {
+		return nil, err
+	}
+	var list []json.RawMessage
+	if strings.HasPrefix(strings.TrimSpace(jsonMsgs), "[") {
+		if err = json.Unmarshal([]byte(jsonMsgs), &list); err != nil {
+			return nil, fmt.Errorf("invalid -grpc-json: %v", err)
+		}
+	} else {
+		list = []json.RawMessage{json.RawMessage(jsonMsgs)}
+	}
+	for _, m := range list {
+		msg, err := desc.EncodeJSON(input, m)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// readArg returns the argument itself, or the content of the file when it is given as @filename
+func readArg(arg string) (string, error) {
+	if len(arg) == 0 || arg[0] != '@' {
+		return arg, nil
+	}
+	filename := arg[1:]
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", fmt.Errorf("could not read file %q: %v", filename, err)
+	}
+	return string(data), nil
+}
+
+// printGroups prints a statistics table with a line per group, sorted by name
+func printGroups(title string, groups map[string]*loader.GroupStats) {
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "%v\tRequests\tErrors\tAvg\t50%%\t99%%\tMax\n", title)
+	for _, name := range names {
+		g := groups[name]
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", name, g.NumRequests, g.NumErrs,
+			toDuration(int64(g.Histogram.Mean())), toDuration(g.Histogram.ValueAtPercentile(50)),
+			toDuration(g.Histogram.ValueAtPercentile(99)), toDuration(g.Histogram.Max()))
+	}
+	w.Flush()
+}
+
+// printStatusCodes a table of the responses by status code, and the latency of the responses that were not successful
+func printStatusCodes(stats *loader.RequesterStats) {
+	if len(stats.StatusCodes) == 0 {
+		return
+	}
+	codes := make([]int, 0, len(stats.StatusCodes))
+	total := 0
+	for code, n := range stats.StatusCodes {
+		codes = append(codes, code)
+		total += n
+	}
+	sort.Ints(codes)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Status\tResponses\t%%\n")
+	for _, code := range codes {
+		n := stats.StatusCodes[code]
+		fmt.Fprintf(w, "%v %v\t%v\t%.2f\n", code, http.StatusText(code), n, 100*float64(n)/float64(total))
+	}
+	w.Flush()
+	if stats.FailedHistogram != nil {
+		printHistogramLine("Failed Response Time:", stats.FailedHistogram)
+	}
+}
+
+// printAssertions a table of the checks with their results, nothing when no response was checked
+func printAssertions(a *loader.AssertStats) {
+	if a == nil || a.Checked == 0 {
+		return
+	}
+	fmt.Printf("Checked Responses:\t%v\n", a.Checked)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Assertion\tPassed\tFailed\n")
+	for _, c := range a.Checks {
+		fmt.Fprintf(w, "%v\t%v\t%v\n", c.Name, c.Passed, c.Failed)
+	}
+	w.Flush()
+}
+
+// labelColumns the -label-sort columns, the numbers are sorted largest first
+var labelColumns = map[string]func(g *loader.GroupStats, d time.Duration) float64{
+	"label":    nil,
+	"requests": func(g *loader.GroupStats, d time.Duration) float64 { return float64(g.NumRequests) },
+	"rps":      func(g *loader.GroupStats, d time.Duration) float64 { return float64(g.NumRequests) / d.Seconds() },
+	"errors":   func(g *loader.GroupStats, d time.Duration) float64 { return float64(g.NumErrs) },
+	"err%":     func(g *loader.GroupStats, d time.Duration) float64 { return errorRate(g) },
+	"avg":      func(g *loader.GroupStats, d time.Duration) float64 { return g.Histogram.Mean() },
+	"p50":      func(g *loader.GroupStats, d time.Duration) float64 { return float64(g.Histogram.ValueAtPercentile(50)) },
+	"p90":      func(g *loader.GroupStats, d time.Duration) float64 { return float64(g.Histogram.ValueAtPercentile(90)) },
+	"p99":      func(g *loader.GroupStats, d time.Duration) float64 { return float64(g.Histogram.ValueAtPercentile(99)) },
+	"max":      func(g *loader.GroupStats, d time.Duration) float64 { return float64(g.Histogram.Max()) },
+}
+
+func errorRate(g *loader.GroupStats) float64 {
+	if g.NumRequests+g.NumErrs == 0 {
+		return 0
+	}
+	return 100 * float64(g.NumErrs) / float64(g.NumRequests+g.NumErrs)
+}
+
+// printLabels a table with a line per request label, sorted by the given column. d is the time the rates are over.
+func printLabels(labels map[string]*loader.GroupStats, d time.Duration, sortBy string) {
+	if len(labels) == 0 {
+		return
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if column := labelColumns[sortBy]; column != nil {
+		sort.SliceStable(names, func(i, j int) bool {
+			return column(labels[names[i]], d) > column(labels[names[j]], d)
+		})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Label\tRequests\tReq/sec\tErrors\tErr%%\tAvg\t50%%\t90%%\t99%%\tMax\n")
+	for _, name := range names {
+		g := labels[name]
+		h := g.Histogram
+		fmt.Fprintf(w, "%v\t%v\t%.2f\t%v\t%.2f\t%v\t%v\t%v\t%v\t%v\n", name, g.NumRequests,
+			float64(g.NumRequests)/d.Seconds(), g.NumErrs, errorRate(g), toDuration(int64(h.Mean())),
+			toDuration(h.ValueAtPercentile(50)), toDuration(h.ValueAtPercentile(90)), toDuration(h.ValueAtPercentile(99)),
+			toDuration(h.Max()))
+	}
+	w.Flush()
+}
+
+// printBytes the traffic in one direction, as the application sees it and on the wire, and its rate over d
+func printBytes(title string, app, wire int64, d time.Duration) {
+	fmt.Printf("%-24s%v app (%v/sec), %v wire (%v/sec)\n", title, util.ByteSize{Size: float64(app)},
+		util.ByteSize{Size: float64(app) / d.Seconds()}, util.ByteSize{Size: float64(wire)},
+		util.ByteSize{Size: float64(wire) / d.Seconds()})
+}
+
+// printIntervals a table with a line per interval, so that a slowdown in the middle of the test stands out
+func printIntervals(s *loader.IntervalSeries) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Time\tRequests\tReq/sec\tErrors\tRead\t50%%\t90%%\t99%%\tMax\tOpened\tClosed\tBy Server\tReuse\n")
+	for _, i := range s.Intervals() {
+		h := i.Histogram
+		fmt.Fprintf(w, "%v\t%v\t%.2f\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", i.Start, i.NumRequests,
+			float64(i.NumRequests)/s.Width.Seconds(), i.NumErrs, util.ByteSize{Size: float64(i.TotRespSize)},
+			toDuration(h.ValueAtPercentile(50)), toDuration(h.ValueAtPercentile(90)), toDuration(h.ValueAtPercentile(99)),
+			toDuration(h.Max()), i.ConnsOpened, i.ConnsClosed, i.ServerCloses, reuseRate(i.ReusedRequests, i.ConnRequests))
+	}
+	w.Flush()
+}
+
+// printConns the connection lifecycle: how many were opened and reused, who closed them and how long they lived
+func printConns(c *loader.ConnStats) {
+	if c.Opened == 0 {
+		return
+	}
+	fmt.Printf("Connections:\t\t%v opened, %v closed by the client, %v by the server, %v still open\n", c.Opened,
+		c.ClientCloses, c.ServerCloses, c.Open)
+	if c.Requests > 0 {
+		fmt.Printf("Connection Reuse:\t%v (%v of %v requests)\n", reuseRate(c.ReusedRequests, c.Requests),
+			c.ReusedRequests, c.Requests)
+		h := c.RequestsHist
+		fmt.Printf("%-24savg %.1f, 50%% %v, 99%% %v, max %v\n", "Requests/Connection:", h.Mean(),
+			h.ValueAtPercentile(50), h.ValueAtPercentile(99), h.Max())
+	}
+	printHistogramLine("Idle Before Reuse:", c.IdleHist)
+	printHistogramLine("Connection Age:", c.LifetimeHist)
+}
+
+// reuseRate the percentage of the requests sent on a reused connection
+func reuseRate(reused, requests int) string {
+	if requests == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2f%%", float64(reused)*100/float64(requests))
+}
+
+// printPhases a percentile table of the request phases, without the phases that never happened
+func printPhases(p *loader.PhaseStats) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Phase\tCount\tAvg\t50%%\t90%%\t99%%\t99.9%%\tMax\n")
+	for _, phase := range p.List() {
+		h := phase.Histogram
+		if h.TotalCount() == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n", phase.Name, h.TotalCount(), toDuration(int64(h.Mean())),
+			toDuration(h.ValueAtPercentile(50)), toDuration(h.ValueAtPercentile(90)), toDuration(h.ValueAtPercentile(99)),
+			toDuration(h.ValueAtPercentile(99.9)), toDuration(h.Max()))
+	}
+	w.Flush()
+}
+
+func printWebSocket(ws *loader.WSStats, duration time.Duration) {
+	fmt.Printf("WebSocket Connects:\t%v (%v failed, %v dropped)\n", ws.Connects, ws.ConnectErrs, ws.Disconnects)
+	fmt.Printf("Messages Sent:\t\t%v (%.2f/sec)\n", ws.MsgsSent, float64(ws.MsgsSent)/duration.Seconds())
+	fmt.Printf("Messages Received:\t%v (%.2f/sec)\n", ws.MsgsRecv, float64(ws.MsgsRecv)/duration.Seconds())
+	fmt.Printf("Unanswered Messages:\t%v\n", ws.Unanswered)
+	printHistogramLine("Connect Time:", ws.ConnectHist)
+	printHistogramLine("Connection Lifetime:", ws.LifetimeHist)
+}
+
+func printStream(s *loader.StreamStats, duration time.Duration) {
+	fmt.Printf("Stream Connects:\t%v (%v reconnects, %v empty polls)\n", s.Connects, s.Reconnects, s.EmptyPolls)
+	fmt.Printf("Events:\t\t\t%v (%.2f/sec)\n", s.Events, float64(s.Events)/duration.Seconds())
+	if s.ConnTime > 0 {
+		fmt.Printf("Events/sec/connection:\t%.2f\n", float64(s.Events)/s.ConnTime.Seconds())
+	}
+	printHistogramLine("Time to First Event:", s.FirstEventHist)
+	printHistogramLine("Inter-Event Gap:", s.GapHist)
+	printHistogramLine("Delivery Delay:", s.DeliveryHist)
+}
+
+func printTLS(t *loader.TLSStats) {
+	fmt.Printf("TLS Handshakes:\t\t%v (%v resumed, %.1f%%)\n", t.Handshakes, t.Resumed, 100*float64(t.Resumed)/float64(t.Handshakes))
+	fmt.Printf("TLS Versions:\t\t%v\n", mapToString(t.Versions))
+	fmt.Printf("TLS Ciphers:\t\t%v\n", mapToString(t.Ciphers))
+	fmt.Printf("ALPN:\t\t\t%v\n", mapToString(t.ALPN))
+}
+
+func printSocket(s *loader.SocketStats, duration time.Duration) {
+	fmt.Printf("Socket Connects:\t%v (%v failed)\n", s.Connects, s.ConnectErrs)
+	fmt.Printf("Sent:\t\t\t%v (%v/sec)\n", util.ByteSize{Size: float64(s.BytesSent)},
+		util.ByteSize{Size: float64(s.BytesSent) / duration.Seconds()})
+	printHistogramLine("Connect Time:", s.ConnectHist)
+}
+
+// printHistogramLine a one line summary of a histogram, nothing when it is empty
+func printHistogramLine(title string, h *histo.Histogram) {
+	if h.TotalCount() == 0 {
+		return
+	}
+	fmt.Printf("%-24savg %v, 50%% %v, 99%% %v, max %v\n", title, toDuration(int64(h.Mean())),
+		toDuration(h.ValueAtPercentile(50)), toDuration(h.ValueAtPercentile(99)), toDuration(h.Max()))
+}
+
 func toDuration(usecs int64) time.Duration {
-	return time.Duration(usecs*1000)
+	return time.Duration(usecs * 1000)
 }
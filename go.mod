@@ -5,6 +5,7 @@ go 1.25.0
 require (
 	github.com/HdrHistogram/hdrhistogram-go v1.2.0
 	golang.org/x/net v0.54.0
+	google.golang.org/protobuf v1.36.11
 )
 
 require golang.org/x/text v0.37.0 // indirect
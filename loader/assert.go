@@ -0,0 +1,275 @@
+package loader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AssertCfg checks made on the successful responses. A response that fails one of them is an error.
+type AssertCfg struct {
+	checks []check
+	sample int // check one response in sample
+}
+
+// check a single assertion, named by its spec
+type check struct {
+	name string
+	eval func(in *assertInput) bool
+}
+
+// assertInput a response being checked, its body is parsed as JSON on first use
+type assertInput struct {
+	resp   *http.Response
+	body   []byte
+	doc    any
+	docErr error
+	parsed bool
+}
+
+func (in *assertInput) json() (any, error) {
+	if !in.parsed {
+		in.parsed = true
+		in.docErr = json.Unmarshal(in.body, &in.doc)
+	}
+	return in.doc, in.docErr
+}
+
+// AssertStats the results of the checks, in the order they were given
+type AssertStats struct {
+	Checked int // responses checked
+	Checks  []CheckStats
+	seen    int
+}
+
+// CheckStats the results of a single check
+type CheckStats struct {
+	Name   string
+	Passed int
+	Failed int
+}
+
+// AssertionError returned when a response fails a check
+type AssertionError struct {
+	Check string // the first check that failed
+}
+
+func (e *AssertionError) Error() string {
+	return "assertion failed: " + e.Check
+}
+
+// NewAssertCfg specs are checks of the form kind:argument -
+//
+//	contains:TEXT         the body contains TEXT
+//	regex:PATTERN         the body matches PATTERN
+//	json:PATH             the JSON body has a value at PATH, e.g. $.data.items[0].id
+//	json:PATH=VALUE       the value at PATH equals VALUE, a JSON value or else a string
+//	header:NAME           the response has the header NAME
+//	header:NAME=VALUE     the header NAME equals VALUE
+//	size:MIN-MAX          the body size is within MIN-MAX bytes, either may be left out
+//	golden:FILE           the body is the same as the content of FILE, compared by SHA-256
+//
+// One response in sample is checked.
+func NewAssertCfg(specs []string, sample int) (*AssertCfg, error) {
+	if sample < 1 {
+		return nil, fmt.Errorf("the assertion sample must be at least 1, got %d", sample)
+	}
+	a := &AssertCfg{sample: sample}
+	for _, spec := range specs {
+		eval, err := parseCheck(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid assertion %q: %v", spec, err)
+		}
+		a.checks = append(a.checks, check{name: spec, eval: eval})
+	}
+	if len(a.checks) == 0 {
+		return nil, fmt.Errorf("no assertions")
+	}
+	return a, nil
+}
+
+// WithAssert checks the successful responses of plain requests
+func WithAssert(a *AssertCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.assert = a
+	}
+}
+
+func parseCheck(spec string) (func(in *assertInput) bool, error) {
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok {
+		return nil, fmt.Errorf("expected kind:argument")
+	}
+	switch kind {
+	case "contains":
+		text := []byte(arg)
+		return func(in *assertInput) bool {
+			return bytes.Contains(in.body, text)
+		}, nil
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(in *assertInput) bool {
+			return re.Match(in.body)
+		}, nil
+	case "json":
+		path, value, hasValue := strings.Cut(arg, "=")
+		keys, err := parseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+		var want any
+		if hasValue && json.Unmarshal([]byte(value), &want) != nil {
+			want = value
+		}
+		return func(in *assertInput) bool {
+			doc, err := in.json()
+			if err != nil {
+				return false
+			}
+			got, found := lookupJSON(doc, keys)
+			return found && (!hasValue || reflect.DeepEqual(got, want))
+		}, nil
+	case "header":
+		name, value, hasValue := strings.Cut(arg, "=")
+		if name == "" {
+			return nil, fmt.Errorf("no header name")
+		}
+		return func(in *assertInput) bool {
+			values, found := in.resp.Header[http.CanonicalHeaderKey(name)]
+			return found && (!hasValue || (len(values) > 0 && values[0] == value))
+		}, nil
+	case "size":
+		from, to, _ := strings.Cut(arg, "-")
+		min, max := int64(0), int64(-1)
+		var err error
+		if from != "" {
+			min, err = strconv.ParseInt(from, 10, 64)
+		}
+		if err == nil && to != "" {
+			max, err = strconv.ParseInt(to, 10, 64)
+		}
+		if err != nil || (max >= 0 && min > max) {
+			return nil, fmt.Errorf("expected a size range MIN-MAX")
+		}
+		return func(in *assertInput) bool {
+			n := int64(len(in.body))
+			return n >= min && (max < 0 || n <= max)
+		}, nil
+	case "golden":
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		want := sha256.Sum256(data)
+		return func(in *assertInput) bool {
+			return sha256.Sum256(in.body) == want
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown kind %q, expected contains, regex, json, header, size or golden", kind)
+}
+
+// parseJSONPath splits a path like $.data.items[0].id into object keys (string) and array indexes (int)
+func parseJSONPath(path string) ([]any, error) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	if path == "" {
+		return nil, nil
+	}
+	var keys []any
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name != "" {
+			keys = append(keys, name)
+		} else if rest == "" {
+			return nil, fmt.Errorf("empty JSON path element")
+		}
+		for rest != "" {
+			index, after, ok := strings.Cut(rest, "]")
+			i, err := strconv.Atoi(index)
+			if !ok || err != nil || i < 0 || (after != "" && after[0] != '[') {
+				return nil, fmt.Errorf("invalid JSON path index in %q", part)
+			}
+			keys = append(keys, i)
+			rest = strings.TrimPrefix(after, "[")
+		}
+	}
+	return keys, nil
+}
+
+func lookupJSON(doc any, keys []any) (any, bool) {
+	for _, key := range keys {
+		switch k := key.(type) {
+		case string:
+			obj, ok := doc.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if doc, ok = obj[k]; !ok {
+				return nil, false
+			}
+		case int:
+			arr, ok := doc.([]any)
+			if !ok || k >= len(arr) {
+				return nil, false
+			}
+			doc = arr[k]
+		}
+	}
+	return doc, true
+}
+
+func (a *AssertCfg) newStats() *AssertStats {
+	s := &AssertStats{Checks: make([]CheckStats, len(a.checks))}
+	for i, c := range a.checks {
+		s.Checks[i].Name = c.name
+	}
+	return s
+}
+
+func (s *AssertStats) merge(o *AssertStats) {
+	s.Checked += o.Checked
+	for i := range o.Checks {
+		s.Checks[i].Passed += o.Checks[i].Passed
+		s.Checks[i].Failed += o.Checks[i].Failed
+	}
+}
+
+// validator the validator of the next response of a session, nil when it is not in the sample
+func (a *AssertCfg) validator(stats *RequesterStats) bodyValidator {
+	if stats.Assertions == nil {
+		stats.Assertions = a.newStats()
+	}
+	s := stats.Assertions
+	s.seen++
+	if (s.seen-1)%a.sample != 0 {
+		return nil
+	}
+	return func(resp *http.Response, body []byte) error {
+		s.Checked++
+		in := &assertInput{resp: resp, body: body}
+		var failed string
+		for i, c := range a.checks {
+			if c.eval(in) {
+				s.Checks[i].Passed++
+			} else {
+				s.Checks[i].Failed++
+				if failed == "" {
+					failed = c.name
+				}
+			}
+		}
+		if failed != "" {
+			return &AssertionError{Check: failed}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,133 @@
+package loader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const assertTestBody = `{"status":"ok","data":{"items":[{"id":7,"name":"a"},{"id":8}]},"total":2}`
+
+func TestNewAssertCfg_Checks(t *testing.T) {
+	golden := filepath.Join(t.TempDir(), "golden.json")
+	if err := os.WriteFile(golden, []byte(assertTestBody), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(t.TempDir(), "other.json")
+	if err := os.WriteFile(other, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	in := &assertInput{resp: &http.Response{Header: http.Header{"Content-Type": {"application/json"}}},
+		body: []byte(assertTestBody)}
+	for _, tc := range []struct {
+		spec string
+		want bool
+	}{
+		{`contains:"status":"ok"`, true},
+		{`contains:error`, false},
+		{`regex:"id":\d+`, true},
+		{`regex:^<html`, false},
+		{`json:$.status=ok`, true},
+		{`json:status="ok"`, true},
+		{`json:$.total=2`, true},
+		{`json:$.total=3`, false},
+		{`json:$.data.items[1].id=8`, true},
+		{`json:$.data.items[0]={"id":7,"name":"a"}`, true},
+		{`json:$.data.items[2]`, false},
+		{`json:$.data.items[0].name`, true},
+		{`json:$.data.missing`, false},
+		{`json:$`, true},
+		{`header:content-type`, true},
+		{`header:Content-Type=application/json`, true},
+		{`header:Content-Type=text/html`, false},
+		{`header:X-Request-Id`, false},
+		{`size:10-`, true},
+		{`size:-10`, false},
+		{`size:73-73`, true},
+		{"golden:" + golden, true},
+		{"golden:" + other, false},
+	} {
+		eval, err := parseCheck(tc.spec)
+		if err != nil {
+			t.Errorf("parseCheck(%q) err = %v", tc.spec, err)
+			continue
+		}
+		if got := eval(in); got != tc.want {
+			t.Errorf("%q = %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+	// not JSON
+	eval, _ := parseCheck("json:$")
+	if eval(&assertInput{body: []byte("<html>")}) {
+		t.Errorf("json:$ passed on an HTML body")
+	}
+
+	for _, spec := range []string{"status", "nope:x", "regex:(", "json:a..b", "json:a[x]", "json:a[0", "header:",
+		"size:x-1", "size:10-1", "golden:" + filepath.Join(t.TempDir(), "missing")} {
+		if _, err := NewAssertCfg([]string{spec}, 1); err == nil {
+			t.Errorf("NewAssertCfg(%q) err = nil, want an error", spec)
+		}
+	}
+	if _, err := NewAssertCfg([]string{"contains:x"}, 0); err == nil {
+		t.Error("NewAssertCfg with a sample of 0 err = nil, want an error")
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	keys, err := parseJSONPath("$.a.b[1][2].c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []any{"a", "b", 1, 2, "c"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys = %v, want %v", keys, want)
+	}
+}
+
+func TestRunSingleLoadSession_Assert(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(assertTestBody))
+	}))
+	defer ts.Close()
+
+	for _, sample := range []int{1, 5} {
+		a, err := NewAssertCfg([]string{"json:$.status=ok", "contains:error page", "header:Content-Type"}, sample)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false,
+			WithAssert(a))
+		stats := runSession(t, cfg, ch)
+
+		s := stats.Assertions
+		if s == nil || s.Checked == 0 {
+			t.Fatalf("sample %d: Assertions = %+v, want checked responses", sample, s)
+		}
+		responses := stats.NumRequests + stats.NumErrs
+		if want := (responses + sample - 1) / sample; s.Checked != want {
+			t.Errorf("sample %d: Checked = %d of %d responses, want %d", sample, s.Checked, responses, want)
+		}
+		want := []CheckStats{{"json:$.status=ok", s.Checked, 0}, {"contains:error page", 0, s.Checked},
+			{"header:Content-Type", s.Checked, 0}}
+		if !reflect.DeepEqual(s.Checks, want) {
+			t.Errorf("sample %d: Checks = %+v, want %+v", sample, s.Checks, want)
+		}
+		// the failed responses are errors, named by their first failed check
+		if stats.NumErrs != s.Checked || errorCount(stats, ERR_ASSERTION) != s.Checked {
+			t.Errorf("sample %d: NumErrs = %d, Errors = %v, want %d failed assertions", sample, stats.NumErrs,
+				stats.Errors, s.Checked)
+		}
+		// their size and latency are counted as those of the other responses
+		if stats.TotRespSize < int64(responses*len(assertTestBody)) {
+			t.Errorf("sample %d: TotRespSize = %d, want all the %d responses", sample, stats.TotRespSize, responses)
+		}
+		if stats.FailedHistogram == nil || stats.FailedHistogram.TotalCount() != int64(stats.NumErrs) {
+			t.Errorf("sample %d: FailedHistogram = %v, want the latency of the %d failed assertions", sample,
+				stats.FailedHistogram, stats.NumErrs)
+		}
+	}
+}
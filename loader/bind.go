@@ -0,0 +1,177 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+)
+
+// the largest number of local addresses a CIDR may expand to
+const maxBindAddrs = 65536
+
+// ErrPortExhaustion a connection could not get a local address and port, usually because all the ephemeral ports of
+// the source address are in use (or in TIME_WAIT)
+var ErrPortExhaustion = errors.New("local port exhaustion: cannot assign requested address")
+
+// BindCfg the local addresses, and optionally ports, the connections are made from. The new connections take the
+// addresses in turn, so the ephemeral ports of all of them are available.
+type BindCfg struct {
+	addrs    []net.IP
+	minPort  int
+	maxPort  int // 0 for ephemeral ports
+	nextAddr uint32
+	nextPort uint32
+}
+
+// NewBindCfg addrs is a comma separated list of local IP addresses and CIDR ranges, ports is an empty string or a
+// lo-hi range of local ports. A CIDR range of IPv4 addresses excludes its network and broadcast addresses.
+func NewBindCfg(addrs, ports string) (*BindCfg, error) {
+	b := &BindCfg{}
+	for _, a := range splitList(addrs) {
+		if ip := net.ParseIP(a); ip != nil {
+			b.addrs = append(b.addrs, ip)
+			continue
+		}
+		ips, err := expandCIDR(a)
+		if err != nil {
+			return nil, err
+		}
+		b.addrs = append(b.addrs, ips...)
+		if len(b.addrs) > maxBindAddrs {
+			return nil, fmt.Errorf("too many bind addresses, at most %v are supported", maxBindAddrs)
+		}
+	}
+	if ports != "" {
+		lo, hi, ok := strings.Cut(ports, "-")
+		var err1, err2 error
+		b.minPort, err1 = strconv.Atoi(strings.TrimSpace(lo))
+		b.maxPort, err2 = strconv.Atoi(strings.TrimSpace(hi))
+		if !ok || err1 != nil || err2 != nil || b.minPort < 1 || b.maxPort > 65535 || b.minPort > b.maxPort {
+			return nil, fmt.Errorf("invalid local port range %q, expected lo-hi between 1 and 65535", ports)
+		}
+	}
+	if len(b.addrs) == 0 && b.maxPort == 0 {
+		return nil, errors.New("no bind addresses or ports")
+	}
+	return b, nil
+}
+
+// WithBind makes the connections from the given local addresses and ports
+func WithBind(b *BindCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.bind = b
+	}
+}
+
+func withBind(b *BindCfg) clientOption {
+	return func(o *clientOpts) {
+		o.bind = b
+	}
+}
+
+func expandCIDR(cidr string) ([]net.IP, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bind address %q, expected an IP address or a CIDR range", cidr)
+	}
+	ones, bits := ipNet.Mask.Size()
+	if bits-ones > 16 {
+		return nil, fmt.Errorf("bind range %q has more than %v addresses", cidr, maxBindAddrs)
+	}
+	var ips []net.IP
+	ip = ip.Mask(ipNet.Mask)
+	for cur := ip; ipNet.Contains(cur); cur = nextIP(cur) {
+		ips = append(ips, cur)
+	}
+	if ip.To4() != nil && bits-ones >= 2 {
+		ips = ips[1 : len(ips)-1]
+	}
+	return ips, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+	next := append(net.IP(nil), ip...)
+	for i := len(next) - 1; i >= 0; i-- {
+		if next[i]++; next[i] != 0 {
+			break
+		}
+	}
+	return next
+}
+
+// localAddr the next local address for a connection to addr. When addr is an IP address, only the local addresses
+// of its family are used.
+func (b *BindCfg) localAddr(network, addr string) net.Addr {
+	var ip net.IP
+	if len(b.addrs) > 0 {
+		first := int(atomic.AddUint32(&b.nextAddr, 1))
+		ip = b.addrs[first%len(b.addrs)]
+		host, _, _ := net.SplitHostPort(addr)
+		if remote := net.ParseIP(host); remote != nil {
+			for i := 1; i < len(b.addrs) && (ip.To4() == nil) != (remote.To4() == nil); i++ {
+				ip = b.addrs[(first+i)%len(b.addrs)]
+			}
+		}
+	}
+	port := 0
+	if b.maxPort > 0 {
+		port = b.minPort + int(atomic.AddUint32(&b.nextPort, 1)-1)%(b.maxPort-b.minPort+1)
+	}
+	if strings.HasPrefix(network, "udp") {
+		return &net.UDPAddr{IP: ip, Port: port}
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+// tries the number of times to try binding a connection, every port of the port range
+func (b *BindCfg) tries() int {
+	if b.maxPort > 0 {
+		return b.maxPort - b.minPort + 1
+	}
+	return 1
+}
+
+// dial a dial function that binds the connections to the local addresses in turn. With a port range, a port that is
+// in use is skipped, until every port of the range was tried.
+func (b *BindCfg) dial(dialer *net.Dialer) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if !strings.HasPrefix(network, "tcp") && !strings.HasPrefix(network, "udp") {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		var conn net.Conn
+		var err error
+		for i := 0; i < b.tries(); i++ {
+			d := *dialer
+			d.LocalAddr = b.localAddr(network, addr)
+			if conn, err = d.DialContext(ctx, network, addr); !isPortExhaustion(err) || ctx.Err() != nil {
+				break
+			}
+		}
+		return conn, err
+	}
+}
+
+func isPortExhaustion(err error) bool {
+	return errors.Is(err, syscall.EADDRNOTAVAIL) || errors.Is(err, syscall.EADDRINUSE)
+}
+
+// baseDial the dial function the connections start from: bound to the local addresses when b is not nil, and
+// reporting a failure to get a local address and port as ErrPortExhaustion
+func baseDial(dialer *net.Dialer, b *BindCfg) dialFunc {
+	dial := dialer.DialContext
+	if b != nil {
+		dial = b.dial(dialer)
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if isPortExhaustion(err) {
+			return nil, ErrPortExhaustion
+		}
+		return conn, err
+	}
+}
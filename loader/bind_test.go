@@ -0,0 +1,108 @@
+package loader
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewBindCfg(t *testing.T) {
+	b, err := NewBindCfg("10.0.0.1, 10.0.1.0/30,fd00::1", "20000-20009")
+	if err != nil {
+		t.Fatalf("NewBindCfg err = %v", err)
+	}
+	// the network and broadcast addresses of the /30 are left out
+	want := []string{"10.0.0.1", "10.0.1.1", "10.0.1.2", "fd00::1"}
+	if len(b.addrs) != len(want) {
+		t.Fatalf("addrs = %v, want %v", b.addrs, want)
+	}
+	for i, ip := range b.addrs {
+		if ip.String() != want[i] {
+			t.Errorf("addrs[%d] = %v, want %v", i, ip, want[i])
+		}
+	}
+	if b.minPort != 20000 || b.maxPort != 20009 {
+		t.Errorf("ports = %d-%d, want 20000-20009", b.minPort, b.maxPort)
+	}
+
+	for _, bad := range [][2]string{{"", ""}, {"10.0.0.256", ""}, {"10.0.0.0/8", ""}, {"", "20000"}, {"", "2-1"}, {"", "0-10"}, {"", "1-65536"}} {
+		if _, err = NewBindCfg(bad[0], bad[1]); err == nil {
+			t.Errorf("NewBindCfg(%q, %q) want err, got nil", bad[0], bad[1])
+		}
+	}
+}
+
+func TestBindCfg_localAddr(t *testing.T) {
+	b, _ := NewBindCfg("10.0.0.1,fd00::1,10.0.0.2", "")
+	seen := make(map[string]int)
+	for i := 0; i < 6; i++ {
+		addr := b.localAddr("tcp", "192.0.2.1:80").(*net.TCPAddr)
+		seen[addr.IP.String()]++
+	}
+	if seen["10.0.0.1"] == 0 || seen["10.0.0.2"] == 0 || seen["fd00::1"] != 0 {
+		t.Errorf("local addresses for an IPv4 target = %v, want only the IPv4 ones", seen)
+	}
+	if addr := b.localAddr("udp", "[2001:db8::1]:53"); addr.(*net.UDPAddr).IP.String() != "fd00::1" {
+		t.Errorf("local address for an IPv6 target = %v, want fd00::1", addr)
+	}
+}
+
+func TestRunSingleLoadSession_Bind(t *testing.T) {
+	var mu sync.Mutex
+	remotes := make(map[string]bool)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		remotes[r.RemoteAddr] = true
+		mu.Unlock()
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	b, err := NewBindCfg("127.0.0.1,127.0.0.2", "41000-41099")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const sessions = 4
+	ch := make(chan *RequesterStats, sessions)
+	cfg := NewLoadCfg(1, sessions, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false,
+		"", "", "", false, WithBind(b))
+	for i := 0; i < sessions; i++ {
+		go cfg.RunSingleLoadSession()
+	}
+	for i := 0; i < sessions; i++ {
+		stats := runSessionResult(t, ch)
+		if stats.NumRequests == 0 || stats.NumErrs != 0 {
+			t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	hosts := make(map[string]int)
+	for remote := range remotes {
+		host, port, _ := net.SplitHostPort(remote)
+		hosts[host]++
+		if p, _ := strconv.Atoi(port); p < 41000 || p > 41099 {
+			t.Errorf("connection from port %v, want 41000-41099", p)
+		}
+	}
+	if hosts["127.0.0.1"] == 0 || hosts["127.0.0.2"] == 0 {
+		t.Errorf("connections by source address = %v, want both addresses", hosts)
+	}
+}
+
+func TestBaseDial_PortExhaustion(t *testing.T) {
+	// TEST-NET-1 is never a local address, so binding to it fails as when no port is left
+	b, _ := NewBindCfg("192.0.2.1", "")
+	dial := baseDial(&net.Dialer{Timeout: time.Second}, b)
+
+	_, err := dial(context.Background(), "tcp", "127.0.0.1:1")
+	if err != ErrPortExhaustion {
+		t.Errorf("err = %v, want ErrPortExhaustion", err)
+	}
+}
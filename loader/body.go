@@ -0,0 +1,82 @@
+package loader
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	BODY_DISCARD = "discard" // read the whole body into pooled buffers, without keeping it
+	BODY_HEADERS = "headers" // stop after the headers, the latency is the time to the first byte
+	BODY_PREFIX  = "prefix"  // read at most a prefix of the body
+	BODY_FULL    = "full"    // buffer the whole body, e.g. for validation
+)
+
+// the size of the pooled read buffers
+const bodyBufSize = 32 * 1024
+
+var bodyBufs = sync.Pool{New: func() any { b := make([]byte, bodyBufSize); return &b }}
+
+// BodyCfg how the response bodies are read. The response size counts the bytes actually read, so a body that is not
+// read to the end is only counted up to where the reading stopped. Such a connection can't be reused for HTTP/1.1.
+type BodyCfg struct {
+	mode   string
+	prefix int64
+}
+
+// NewBodyCfg mode is one of BODY_DISCARD, BODY_HEADERS, BODY_PREFIX or BODY_FULL. prefix is the number of bytes read
+// in BODY_PREFIX mode.
+func NewBodyCfg(mode string, prefix int64) (*BodyCfg, error) {
+	switch mode {
+	case BODY_DISCARD, BODY_HEADERS, BODY_FULL:
+	case BODY_PREFIX:
+		if prefix <= 0 {
+			return nil, fmt.Errorf("the %v body mode requires a positive prefix length", BODY_PREFIX)
+		}
+	default:
+		return nil, fmt.Errorf("unknown body mode %q, expected %v, %v, %v or %v", mode, BODY_DISCARD, BODY_HEADERS,
+			BODY_PREFIX, BODY_FULL)
+	}
+	return &BodyCfg{mode: mode, prefix: prefix}, nil
+}
+
+// WithBody sets how the response bodies of plain requests are read, BODY_DISCARD by default
+func WithBody(b *BodyCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.body = b
+	}
+}
+
+// Mode the body mode
+func (b *BodyCfg) Mode() string {
+	if b == nil {
+		return BODY_DISCARD
+	}
+	return b.mode
+}
+
+// read reads a body according to the mode. It returns the number of bytes read, and the body itself in BODY_FULL
+// mode.
+func (b *BodyCfg) read(body io.Reader) (n int64, data []byte, err error) {
+	switch b.Mode() {
+	case BODY_HEADERS:
+		return 0, nil, nil
+	case BODY_FULL:
+		data, err = io.ReadAll(body)
+		return int64(len(data)), data, err
+	case BODY_PREFIX:
+		body = io.LimitReader(body, b.prefix)
+	}
+	buf := bodyBufs.Get().(*[]byte)
+	defer bodyBufs.Put(buf)
+	for {
+		m, err := body.Read(*buf)
+		n += int64(m)
+		if err == io.EOF {
+			return n, nil, nil
+		} else if err != nil {
+			return n, nil, err
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package loader
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewBodyCfg(t *testing.T) {
+	for _, mode := range []string{BODY_DISCARD, BODY_HEADERS, BODY_FULL} {
+		if _, err := NewBodyCfg(mode, 0); err != nil {
+			t.Errorf("NewBodyCfg(%q) err = %v", mode, err)
+		}
+	}
+	if _, err := NewBodyCfg(BODY_PREFIX, 0); err == nil {
+		t.Error("NewBodyCfg(prefix, 0) want err, got nil")
+	}
+	if _, err := NewBodyCfg("all", 0); err == nil {
+		t.Error("NewBodyCfg(all) want err, got nil")
+	}
+	if (*BodyCfg)(nil).Mode() != BODY_DISCARD {
+		t.Errorf("the default mode = %q, want %q", (*BodyCfg)(nil).Mode(), BODY_DISCARD)
+	}
+}
+
+func TestDoRequest_BodyModes(t *testing.T) {
+	const bodyLen = 100000
+	const delay = 200 * time.Millisecond
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100000")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// the body arrives well after the headers
+		time.Sleep(delay)
+		_, _ = w.Write([]byte(strings.Repeat("x", bodyLen)))
+	})
+
+	cases := []struct {
+		mode     string
+		prefix   int64
+		bodySize int
+		slow     bool // the latency includes the delayed body
+	}{
+		{BODY_HEADERS, 0, 0, false},
+		{BODY_DISCARD, 0, bodyLen, true},
+		{BODY_FULL, 0, bodyLen, true},
+		{BODY_PREFIX, 1000, 1000, true},
+	}
+	headerSize := 0 // the size of the response in headers mode
+	for _, tc := range cases {
+		t.Run(tc.mode, func(t *testing.T) {
+			body, _ := NewBodyCfg(tc.mode, tc.prefix)
+			respSize, dur, err := doRequest(defaultTestClient(t), nil, "GET", "", ts.URL, "", body, nil, nil, nil)
+			if err != nil {
+				t.Fatalf("doRequest err = %v", err)
+			}
+			if tc.mode == BODY_HEADERS {
+				headerSize = respSize
+			}
+			if respSize <= 0 || respSize != headerSize+tc.bodySize {
+				t.Errorf("respSize = %d, want the %d bytes of headers and %d of body", respSize, headerSize, tc.bodySize)
+			}
+			if tc.slow != (dur >= delay) {
+				t.Errorf("duration = %v, want it to include the %v body delay: %v", dur, delay, tc.slow)
+			}
+		})
+	}
+}
+
+func TestDoRequest_ValidatedBodyIsFull(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello world"))
+	})
+	var got string
+	validate := func(resp *http.Response, body []byte) error {
+		got = string(body)
+		return nil
+	}
+	headersOnly, _ := NewBodyCfg(BODY_HEADERS, 0)
+	if _, _, err := doRequest(defaultTestClient(t), nil, "GET", "", ts.URL, "", headersOnly, nil, validate, nil); err != nil {
+		t.Fatalf("doRequest err = %v", err)
+	}
+	if got != "hello world" {
+		t.Errorf("validated body = %q, want the full body", got)
+	}
+}
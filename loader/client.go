@@ -1,29 +1,85 @@
 package loader
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"slices"
 
 	"fmt"
 
+	"github.com/tsliwowicz/go-wrk/util"
 	"golang.org/x/net/http2"
 	"time"
-	"github.com/tsliwowicz/go-wrk/util"
 )
 
-func client(disableCompression, disableKeepAlive, skipVerify bool, timeoutms int, allowRedirects bool, clientCert, clientKey, caCert string, usehttp2 bool) (*http.Client, error) {
+const (
+	PROTO_AUTO = "auto" // HTTP/2 when negotiated by TLS ALPN, HTTP/1.1 otherwise
+	PROTO_H1   = "h1"   // HTTP/1.1 only
+	PROTO_H2   = "h2"   // HTTP/2 over TLS only
+	PROTO_H2C  = "h2c"  // cleartext HTTP/2 with prior knowledge
+)
 
-	client := &http.Client{}
-	//overriding the default parameters
-	client.Transport = &http.Transport{
-		DisableCompression:    disableCompression,
-		DisableKeepAlives:     disableKeepAlive,
-		ResponseHeaderTimeout: time.Millisecond * time.Duration(timeoutms),
-		TLSClientConfig:       &tls.Config{InsecureSkipVerify: skipVerify},
+// clientOpts client settings that are not required for the basic http load
+type clientOpts struct {
+	proto          string
+	h2             *H2Cfg
+	streaming      bool   // responses may take any time to start and to end, no response timeouts
+	unixSocket     string // path of a unix domain socket all the connections are made to, instead of the url host
+	proxy          *ProxyCfg
+	tls            *TLSCfg
+	resolve        *ResolveCfg
+	bind           *BindCfg
+	expectContinue time.Duration // how long to wait for a 100 Continue before sending the body
+	wire           *WireStats    // counts the bytes of the connections
+	conns          *ConnTracker  // follows the lifecycle of the connections
+}
+
+type clientOption func(*clientOpts)
+
+func withProto(proto string) clientOption {
+	return func(o *clientOpts) {
+		o.proto = proto
+	}
+}
+
+func withUnixSocket(path string) clientOption {
+	return func(o *clientOpts) {
+		o.unixSocket = path
+	}
+}
+
+// ValidProto checks the value of a protocol selection
+func ValidProto(proto string) error {
+	switch proto {
+	case PROTO_AUTO, PROTO_H1, PROTO_H2, PROTO_H2C:
+		return nil
+	}
+	return fmt.Errorf("unknown protocol %q, expected one of %v, %v, %v or %v", proto, PROTO_H1, PROTO_H2, PROTO_H2C, PROTO_AUTO)
+}
+
+func client(disableCompression, disableKeepAlive, skipVerify bool, timeoutms int, allowRedirects bool, clientCert, clientKey, caCert string, usehttp2 bool, opts ...clientOption) (*http.Client, error) {
+	co := clientOpts{proto: PROTO_AUTO}
+	for _, opt := range opts {
+		opt(&co)
+	}
+	if err := ValidProto(co.proto); err != nil {
+		return nil, err
 	}
 
+	tlsConfig, err := clientTLSConfig(skipVerify, clientCert, clientKey, caCert)
+	if err != nil {
+		return nil, err
+	}
+	if co.tls != nil {
+		co.tls.apply(tlsConfig)
+	}
+
+	client := &http.Client{}
+
 	if !allowRedirects {
 		//returning an error when trying to redirect. This prevents the redirection from happening.
 		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
@@ -31,8 +87,87 @@ func client(disableCompression, disableKeepAlive, skipVerify bool, timeoutms int
 		}
 	}
 
+	dialer := &net.Dialer{Timeout: time.Millisecond * time.Duration(timeoutms), KeepAlive: 30 * time.Second}
+	dial := baseDial(dialer, co.bind)
+	if co.resolve != nil {
+		dial = co.resolve.wrapDial(dial)
+	}
+	if co.unixSocket != "" {
+		dial = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", co.unixSocket)
+		}
+	}
+	if co.wire != nil {
+		dial = co.wire.wrapDial(dial)
+	}
+	if co.conns != nil {
+		dial = co.conns.wrapDial(dial)
+	}
+	responseTimeout := time.Millisecond * time.Duration(timeoutms)
+	if co.streaming {
+		responseTimeout = 0
+	}
+
+	h2only := co.proto == PROTO_H2 || co.proto == PROTO_H2C
+	if h2only && co.proxy != nil {
+		// HTTP/2 only connections are tunneled through the proxy, h2c requests can't be forwarded by it
+		dial = co.proxy.wrapDial(dial)
+	}
+
+	//overriding the default parameters
+	t := &http.Transport{
+		DialContext:           dial,
+		DisableCompression:    disableCompression,
+		DisableKeepAlives:     disableKeepAlive,
+		ResponseHeaderTimeout: responseTimeout,
+		TLSClientConfig:       tlsConfig,
+		ExpectContinueTimeout: co.expectContinue,
+	}
+
+	if co.proxy != nil && !h2only {
+		t.Proxy = co.proxy.proxyFunc
+	}
+
+	if !h2only && (co.proto == PROTO_H1 || !usehttp2 || co.tls != nil && len(co.tls.alpn) > 0 && !slices.Contains(co.tls.alpn, "h2")) {
+		// a non nil empty map disables the HTTP/2 upgrade
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else {
+		// a custom TLSClientConfig or DialContext turns off the automatic HTTP/2 support
+		t.ForceAttemptHTTP2 = true
+		if h2only {
+			// no HTTP/1.1 fallback. h2c is spoken with prior knowledge over a plain TCP (or unix socket) connection,
+			// no upgrade
+			t.Protocols = new(http.Protocols)
+			t.Protocols.SetHTTP2(co.proto == PROTO_H2)
+			t.Protocols.SetUnencryptedHTTP2(co.proto == PROTO_H2C)
+		}
+		if co.h2 != nil {
+			t.HTTP2 = co.h2.http2Config()
+		}
+		t2, err := http2.ConfigureTransports(t)
+		if err != nil {
+			return nil, err
+		}
+		if co.h2 != nil {
+			co.h2.configure(t2)
+		}
+		if co.tls != nil && len(co.tls.alpn) > 0 && !h2only {
+			// the transports add h2 and http/1.1 to the offered protocols, -alpn offers its own list. Without http/1.1
+			// in it, only HTTP/2 is used.
+			tlsConfig.NextProtos = co.tls.alpn
+			t.Protocols = new(http.Protocols)
+			t.Protocols.SetHTTP1(slices.Contains(co.tls.alpn, "http/1.1"))
+			t.Protocols.SetHTTP2(true)
+		}
+	}
+	client.Transport = t
+	return client, nil
+}
+
+// clientTLSConfig the tls configuration, including the client certificate and CA when those are given
+func clientTLSConfig(skipVerify bool, clientCert, clientKey, caCert string) (*tls.Config, error) {
 	if clientCert == "" && clientKey == "" && caCert == "" {
-		return client, nil
+		return &tls.Config{InsecureSkipVerify: skipVerify}, nil
 	}
 
 	if clientCert == "" {
@@ -63,13 +198,5 @@ func client(disableCompression, disableKeepAlive, skipVerify bool, timeoutms int
 	}
 
 	tlsConfig.BuildNameToCertificate()
-	t := &http.Transport{
-		TLSClientConfig: tlsConfig,
-	}
-
-	if usehttp2 {
-		http2.ConfigureTransport(t)
-	}
-	client.Transport = t
-	return client, nil
+	return tlsConfig, nil
 }
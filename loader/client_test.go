@@ -1,13 +1,20 @@
 package loader
 
 import (
+	"crypto/tls"
 	"errors"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/tsliwowicz/go-wrk/util"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 func TestClient_Default(t *testing.T) {
@@ -113,3 +120,151 @@ func TestClient_BadCertFiles(t *testing.T) {
 		t.Errorf("err = %q, want substring %q", err.Error(), "Unable to load cert")
 	}
 }
+
+func TestClient_UnknownProto(t *testing.T) {
+	if _, err := client(false, false, false, 1000, true, "", "", "", false, withProto("h3")); err == nil {
+		t.Fatal("want err for unknown protocol, got nil")
+	}
+}
+
+func TestClient_H2Windows(t *testing.T) {
+	tlsServer := httptest.NewUnstartedServer(nil)
+	tlsServer.StartTLS()
+	t.Cleanup(tlsServer.Close)
+
+	const connWindow, streamWindow = 1 << 22, 1 << 20
+	for _, proto := range []string{PROTO_H2, PROTO_H2C} {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		url := "http://" + l.Addr().String()
+		if proto == PROTO_H2 {
+			l = tls.NewListener(l, &tls.Config{Certificates: tlsServer.TLS.Certificates, NextProtos: []string{"h2"}})
+			url = "https://" + l.Addr().String()
+		}
+
+		// the windows the client announces before its first request
+		got := make(chan [2]uint32, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if _, err = io.ReadFull(conn, make([]byte, len(http2.ClientPreface))); err != nil {
+				return
+			}
+			var windows [2]uint32
+			fr := http2.NewFramer(conn, conn)
+			for {
+				f, err := fr.ReadFrame()
+				if err != nil {
+					return
+				}
+				switch f := f.(type) {
+				case *http2.SettingsFrame:
+					windows[1], _ = f.Value(http2.SettingInitialWindowSize)
+				case *http2.WindowUpdateFrame:
+					if f.StreamID == 0 {
+						// the connection window is announced as an increment of the initial one
+						windows[0] = f.Increment
+					}
+				case *http2.HeadersFrame:
+					got <- windows
+					return
+				}
+			}
+		}()
+
+		c, err := client(false, false, true, 1000, true, "", "", "", false, withProto(proto),
+			withHTTP2(&H2Cfg{ConnWindow: connWindow, StreamWindow: streamWindow}))
+		if err != nil {
+			t.Fatalf("%v: client() err = %v", proto, err)
+		}
+		go func() { _, _ = c.Get(url) }()
+		select {
+		case windows := <-got:
+			if windows != [2]uint32{connWindow, streamWindow} {
+				t.Errorf("%v: connection and stream windows = %v, want %v", proto, windows, [2]uint32{connWindow, streamWindow})
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v: no request", proto)
+		}
+	}
+}
+
+func TestClient_Protocols(t *testing.T) {
+	tlsServer := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	t.Cleanup(tlsServer.Close)
+
+	h2cServer := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), &http2.Server{}))
+	t.Cleanup(h2cServer.Close)
+
+	cases := []struct {
+		name     string
+		url      string
+		proto    string
+		usehttp2 bool
+		want     string
+	}{
+		{"auto_tls", tlsServer.URL, PROTO_AUTO, true, "HTTP/2.0"},
+		{"auto_tls_http2_off", tlsServer.URL, PROTO_AUTO, false, "HTTP/1.1"},
+		{"auto_plain", h2cServer.URL, PROTO_AUTO, true, "HTTP/1.1"},
+		{"h1_tls", tlsServer.URL, PROTO_H1, true, "HTTP/1.1"},
+		{"h2_tls", tlsServer.URL, PROTO_H2, false, "HTTP/2.0"},
+		{"h2c", h2cServer.URL, PROTO_H2C, false, "HTTP/2.0"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := client(false, false, true, 5000, true, "", "", "", tc.usehttp2, withProto(tc.proto))
+			if err != nil {
+				t.Fatalf("client() err = %v", err)
+			}
+			resp, err := c.Get(tc.url)
+			if err != nil {
+				t.Fatalf("GET %s err = %v", tc.url, err)
+			}
+			resp.Body.Close()
+			if resp.Proto != tc.want {
+				t.Errorf("Proto = %q, want %q", resp.Proto, tc.want)
+			}
+		})
+	}
+}
+
+func TestClient_UnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewUnstartedServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host + r.URL.Path))
+	}), &http2.Server{}))
+	ts.Listener = l
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	for _, proto := range []string{PROTO_AUTO, PROTO_H2C} {
+		t.Run(proto, func(t *testing.T) {
+			c, err := client(false, false, false, 5000, true, "", "", "", true, withProto(proto), withUnixSocket(sock))
+			if err != nil {
+				t.Fatalf("client() err = %v", err)
+			}
+			// the url host is not resolved, every connection is made to the socket
+			resp, err := c.Get("http://api.invalid/health")
+			if err != nil {
+				t.Fatalf("GET err = %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(body) != "api.invalid/health" {
+				t.Errorf("body = %q, want %q", body, "api.invalid/health")
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package loader
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	histo "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// ConnTracker follows the connections of a test from the dial to the close, shared by its sessions. The connections
+// of the epoll engine are not followed.
+type ConnTracker struct {
+	keepAlive bool // with keep-alive off, the connections are closed by the client after their request
+	intervals *IntervalSeries
+	mu        sync.Mutex
+	open      map[*trackedConn]struct{}
+	stats     ConnStats
+}
+
+// ConnStats the lifecycle of the connections of a test
+type ConnStats struct {
+	Opened         int
+	Open           int // still open when the statistics were taken
+	ClientCloses   int
+	ServerCloses   int              // the server closed the connection first, or its response asked for the close
+	Requests       int              // the requests sent on the connections, by the net/http and raw engines
+	ReusedRequests int              // of Requests, the ones sent on a connection that had been used before
+	IdleHist       *histo.Histogram // how long a reused connection was idle before the request
+	LifetimeHist   *histo.Histogram // from the dial to the close, or to when the statistics were taken
+	RequestsHist   *histo.Histogram // the requests sent on a connection - a count, not microseconds
+}
+
+// trackedConn a connection followed by a ConnTracker
+type trackedConn struct {
+	net.Conn
+	tracker *ConnTracker
+	opened  time.Time
+	// guarded by tracker.mu
+	requests     int
+	closed       bool
+	closedIn     int // the interval of the close
+	serverClosed bool
+}
+
+func newConnTracker(duration int, keepAlive bool, intervals *IntervalSeries) *ConnTracker {
+	return &ConnTracker{keepAlive: keepAlive, intervals: intervals, open: make(map[*trackedConn]struct{}),
+		stats: ConnStats{IdleHist: newHistogram(duration), LifetimeHist: newHistogram(duration),
+			RequestsHist: newHistogram(duration)}}
+}
+
+func withConns(t *ConnTracker) clientOption {
+	return func(o *clientOpts) {
+		o.conns = t
+	}
+}
+
+// wrapDial follows the connections made by dial
+func (t *ConnTracker) wrapDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		c := &trackedConn{Conn: conn, tracker: t, opened: time.Now()}
+		t.mu.Lock()
+		t.open[c] = struct{}{}
+		t.stats.Opened++
+		t.interval(func(i *IntervalStats) { i.ConnsOpened++ })
+		t.mu.Unlock()
+		return c, nil
+	}
+}
+
+// Stats the statistics of the connections so far
+func (t *ConnTracker) Stats() *ConnStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.stats
+	s.IdleHist = histo.Import(t.stats.IdleHist.Export())
+	s.LifetimeHist = histo.Import(t.stats.LifetimeHist.Export())
+	s.RequestsHist = histo.Import(t.stats.RequestsHist.Export())
+	s.Open = len(t.open)
+	now := time.Now()
+	for c := range t.open {
+		recordClamped(s.LifetimeHist, now.Sub(c.opened).Microseconds())
+		s.RequestsHist.RecordValue(int64(c.requests))
+	}
+	return &s
+}
+
+// interval applies f to the current interval, when there are intervals. The caller holds mu.
+func (t *ConnTracker) interval(f func(*IntervalStats)) {
+	if t.intervals == nil {
+		return
+	}
+	t.intervals.mu.Lock()
+	f(t.intervals.at(t.intervals.index(time.Now())))
+	t.intervals.mu.Unlock()
+}
+
+// request counts a request sent on c, idle is how long c was idle before it when known
+func (t *ConnTracker) request(c *trackedConn, idle time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reused := c.requests > 0
+	c.requests++
+	t.stats.Requests++
+	if reused {
+		t.stats.ReusedRequests++
+		if idle > 0 {
+			recordClamped(t.stats.IdleHist, idle.Microseconds())
+		}
+	}
+	t.interval(func(i *IntervalStats) {
+		i.ConnRequests++
+		if reused {
+			i.ReusedRequests++
+		}
+	})
+}
+
+// serverClose marks a connection the server closed, or asked to close. A close that was already counted as the
+// client's is moved to the server.
+func (t *ConnTracker) serverClose(c *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.serverClosed || !t.keepAlive && c.requests > 0 {
+		return
+	}
+	c.serverClosed = true
+	if !c.closed {
+		return
+	}
+	t.stats.ClientCloses--
+	t.stats.ServerCloses++
+	if t.intervals != nil {
+		t.intervals.mu.Lock()
+		t.intervals.at(c.closedIn).ServerCloses++
+		t.intervals.mu.Unlock()
+	}
+}
+
+func (t *ConnTracker) close(c *trackedConn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	delete(t.open, c)
+	// the transports close a connection once they read its end, which comes after a TLS close_notify alert
+	c.serverClosed = c.serverClosed || (t.keepAlive || c.requests == 0) && peerClosed(c.Conn)
+	if c.serverClosed {
+		t.stats.ServerCloses++
+	} else {
+		t.stats.ClientCloses++
+	}
+	recordClamped(t.stats.LifetimeHist, time.Since(c.opened).Microseconds())
+	t.stats.RequestsHist.RecordValue(int64(c.requests))
+	if t.intervals != nil {
+		c.closedIn = t.intervals.index(time.Now())
+	}
+	t.interval(func(i *IntervalStats) {
+		i.ConnsClosed++
+		if c.serverClosed {
+			i.ServerCloses++
+		}
+	})
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err == io.EOF || err != nil && errors.Is(err, syscall.ECONNRESET) {
+		c.tracker.serverClose(c)
+	}
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.tracker.close(c)
+	return c.Conn.Close()
+}
+
+// tracked the trackedConn under conn, through the TLS and byte counting layers, nil if it is not tracked
+func tracked(conn net.Conn) *trackedConn {
+	for conn != nil {
+		if c, ok := conn.(*trackedConn); ok {
+			return c
+		}
+		u, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil
+		}
+		conn = u.NetConn()
+	}
+	return nil
+}
+
+// trackRequest counts a request sent on conn, when it is tracked
+func trackRequest(conn net.Conn, idle time.Duration) {
+	if c := tracked(conn); c != nil {
+		c.tracker.request(c, idle)
+	}
+}
+
+// trackServerClose marks conn as closed by the server, when it is tracked
+func trackServerClose(conn net.Conn) {
+	if c := tracked(conn); c != nil {
+		c.tracker.serverClose(c)
+	}
+}
+
+// recordClamped records v, or the highest value h can record when v is above it
+func recordClamped(h *histo.Histogram, v int64) {
+	if v > h.HighestTrackableValue() {
+		v = h.HighestTrackableValue()
+	}
+	h.RecordValue(v)
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package loader
+
+import "net"
+
+// peerClosed the end of a connection is only seen when it is read
+func peerClosed(conn net.Conn) bool {
+	return false
+}
@@ -0,0 +1,113 @@
+package loader
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunSingleLoadSession_Conns(t *testing.T) {
+	var n atomic.Int64
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server ends every tenth connection
+		if n.Add(1)%10 == 0 {
+			w.Header().Set("Connection", "close")
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	ts.StartTLS()
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name     string
+		noKA     bool
+		options  []Option
+		reused   bool // requests are sent on reused connections
+		byServer bool // the server closes connections
+	}{
+		{"keep-alive", false, nil, true, true},
+		{"no-ka", true, nil, false, false},
+		{"raw", false, []Option{WithRaw(&RawCfg{})}, true, true},
+	} {
+		url := ts.URL
+		if tc.name == "raw" {
+			// the raw engine makes plain HTTP/1.1 connections
+			plain := httptest.NewServer(ts.Config.Handler)
+			defer plain.Close()
+			url = plain.URL
+		}
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, url, "", "GET", "", nil, ch, 1000, true, false, tc.noKA, true, "", "", "", false,
+			append(tc.options, WithInterval(100*time.Millisecond))...)
+		stats := runSession(t, cfg, ch)
+		if stats.NumRequests == 0 || stats.NumErrs != 0 || stats.Conns == nil {
+			t.Fatalf("%v: NumRequests = %d, NumErrs = %d, Errors = %v", tc.name, stats.NumRequests, stats.NumErrs,
+				stats.Errors)
+		}
+		c := stats.Conns.Stats()
+		if c.Requests != stats.NumRequests || c.Opened == 0 || c.Opened != c.ClientCloses+c.ServerCloses+c.Open {
+			t.Errorf("%v: %+v for %d requests", tc.name, c, stats.NumRequests)
+		}
+		if (c.ReusedRequests > 0) != tc.reused || (c.ServerCloses > 0) != tc.byServer {
+			t.Errorf("%v: %d reused requests and %d server closes, want reuse %v and server closes %v", tc.name,
+				c.ReusedRequests, c.ServerCloses, tc.reused, tc.byServer)
+		}
+		if c.ReusedRequests != c.Requests-c.Opened || c.RequestsHist.TotalCount() != int64(c.Opened) {
+			t.Errorf("%v: %d reused of %d requests on %d connections, %d recorded", tc.name, c.ReusedRequests,
+				c.Requests, c.Opened, c.RequestsHist.TotalCount())
+		}
+		var opened, closed, requests int
+		for _, i := range stats.Intervals.Intervals() {
+			opened += i.ConnsOpened
+			closed += i.ConnsClosed
+			requests += i.ConnRequests
+		}
+		if opened != c.Opened || closed != c.ClientCloses+c.ServerCloses || requests != c.Requests {
+			t.Errorf("%v: the intervals have %d opened, %d closed and %d requests, want %d, %d and %d", tc.name, opened,
+				closed, requests, c.Opened, c.ClientCloses+c.ServerCloses, c.Requests)
+		}
+	}
+}
+
+func TestConnTracker_PeerClosed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	tracker := newConnTracker(1, true, nil)
+	dial := tracker.wrapDial((&net.Dialer{}).DialContext)
+	for _, serverFirst := range []bool{true, false} {
+		conn, err := dial(context.Background(), "tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := <-accepted
+		if serverFirst {
+			server.Close()
+			// the FIN has to arrive before the client closes
+			time.Sleep(50 * time.Millisecond)
+		}
+		conn.Close()
+		server.Close()
+	}
+	c := tracker.Stats()
+	if c.Opened != 2 || c.ServerCloses != 1 || c.ClientCloses != 1 || c.Open != 0 {
+		t.Errorf("Stats() = %+v, want 2 opened, 1 closed by the server and 1 by the client", c)
+	}
+}
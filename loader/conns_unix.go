@@ -0,0 +1,36 @@
+//go:build unix
+
+package loader
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerClosed a non-blocking read of a connection that is about to be closed, true when the peer closed it first. A
+// byte of data that was never read is lost, the connection is done anyway.
+func peerClosed(conn net.Conn) bool {
+	for {
+		u, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = u.NetConn()
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return false
+	}
+	closed := false
+	_ = raw.Read(func(fd uintptr) bool {
+		var b [1]byte
+		n, err := syscall.Read(int(fd), b[:])
+		closed = n == 0 && err == nil || err == syscall.ECONNRESET
+		return true
+	})
+	return closed
+}
@@ -0,0 +1,64 @@
+package loader
+
+import (
+	"time"
+
+	histo "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// ContinueStats the Expect: 100-continue handshakes of the requests with a body
+type ContinueStats struct {
+	Requests  int
+	Continued int              // the server answered 100 Continue, and the body was sent
+	Rejected  int              // the server sent its final response before the body was sent
+	Expired   int              // no answer within the wait timeout, the body was sent anyway
+	WaitHist  *histo.Histogram // from writing the headers to the 100 Continue, or the early final response
+}
+
+// WithExpectContinue sends the requests that have a body with Expect: 100-continue, and waits up to timeout for the
+// server to answer before sending the body anyway. 0 turns it off.
+func WithExpectContinue(timeout time.Duration) Option {
+	return func(cfg *LoadCfg) {
+		cfg.expectContinue = timeout
+	}
+}
+
+func withExpectContinue(timeout time.Duration) clientOption {
+	return func(o *clientOpts) {
+		o.expectContinue = timeout
+	}
+}
+
+func newContinueStats(duration int) *ContinueStats {
+	return &ContinueStats{WaitHist: newHistogram(duration)}
+}
+
+func (c *ContinueStats) merge(o *ContinueStats) {
+	c.Requests += o.Requests
+	c.Continued += o.Continued
+	c.Rejected += o.Rejected
+	c.Expired += o.Expired
+	c.WaitHist.Merge(o.WaitHist)
+}
+
+// recordContinue records the handshake of a request that was sent with Expect: 100-continue
+func (cfg *LoadCfg) recordContinue(stats *RequesterStats, res *reqResult) {
+	if cfg.expectContinue <= 0 || len(cfg.reqBody) == 0 || res.wroteHeaders.IsZero() {
+		return
+	}
+	if stats.Continue == nil {
+		stats.Continue = newContinueStats(cfg.duration)
+	}
+	c := stats.Continue
+	c.Requests++
+	switch {
+	case !res.got100.IsZero():
+		c.Continued++
+		c.WaitHist.RecordValue(res.got100.Sub(res.wroteHeaders).Microseconds())
+	case res.bodyUnsent() && !res.firstByte.IsZero():
+		c.Rejected++
+		c.WaitHist.RecordValue(res.firstByte.Sub(res.wroteHeaders).Microseconds())
+	default:
+		c.Expired++
+	}
+}
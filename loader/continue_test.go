@@ -0,0 +1,114 @@
+package loader
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func runContinueSession(t *testing.T, url string, timeout time.Duration) *RequesterStats {
+	t.Helper()
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, url, strings.Repeat("u", 4096), "PUT", "", nil, ch, 1000, true, false, false, false,
+		"", "", "", false, WithExpectContinue(timeout))
+	return runSession(t, cfg, ch)
+}
+
+func TestRunSingleLoadSession_ExpectContinue(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Expect") != "100-continue" {
+			t.Errorf("Expect = %q, want 100-continue", r.Header.Get("Expect"))
+		}
+		if r.URL.Path == "/reject" {
+			// rejected by the headers, the body is never read
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		// the server answers 100 Continue when the body is read
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer ts.Close()
+
+	stats := runContinueSession(t, ts.URL+"/accept", time.Second)
+	c := stats.Continue
+	if stats.NumRequests == 0 || stats.NumErrs != 0 || c == nil {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v, Continue = %+v", stats.NumRequests, stats.NumErrs,
+			stats.Errors, c)
+	}
+	if c.Requests != stats.NumRequests || c.Continued != c.Requests || c.Rejected != 0 || c.Expired != 0 {
+		t.Errorf("Continue = %+v, want all the %d requests continued", c, stats.NumRequests)
+	}
+	if c.WaitHist.TotalCount() != int64(c.Continued) {
+		t.Errorf("WaitHist count = %d, want %d", c.WaitHist.TotalCount(), c.Continued)
+	}
+
+	stats = runContinueSession(t, ts.URL+"/reject", time.Second)
+	c = stats.Continue
+	if stats.NumErrs == 0 || errorCount(stats, ERR_STATUS) != stats.NumErrs || c == nil {
+		t.Fatalf("NumErrs = %d, Errors = %v, Continue = %+v, want only 413s", stats.NumErrs, stats.Errors, c)
+	}
+	if c.Rejected != c.Requests || c.Requests != stats.NumErrs || c.WaitHist.TotalCount() != int64(c.Rejected) {
+		t.Errorf("Continue = %+v, want all the %d requests rejected", c, stats.NumErrs)
+	}
+}
+
+func TestRunSingleLoadSession_ExpectContinueExpires(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// an HTTP/1.0 style server that never answers 100 Continue, it just waits for the body
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				for {
+					length := 0
+					for {
+						line, err := rd.ReadString('\n')
+						if err != nil {
+							return
+						}
+						if name, value, ok := strings.Cut(line, ":"); ok && strings.EqualFold(name, "Content-Length") {
+							length, _ = strconv.Atoi(strings.TrimSpace(value))
+						}
+						if line == "\r\n" {
+							break
+						}
+					}
+					if _, err := io.CopyN(io.Discard, rd, int64(length)); err != nil {
+						return
+					}
+					_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+				}
+			}()
+		}
+	}()
+
+	stats := runContinueSession(t, "http://"+l.Addr().String()+"/", 20*time.Millisecond)
+
+	c := stats.Continue
+	if stats.NumRequests == 0 || stats.NumErrs != 0 || c == nil {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v, Continue = %+v", stats.NumRequests, stats.NumErrs,
+			stats.Errors, c)
+	}
+	if c.Expired != stats.NumRequests || c.Continued != 0 || c.WaitHist.TotalCount() != 0 {
+		t.Errorf("Continue = %+v, want all the %d requests sent after the wait", c, stats.NumRequests)
+	}
+	// every request waited for the timeout
+	if min := stats.Histogram.Min(); min < (20 * time.Millisecond).Microseconds() {
+		t.Errorf("fastest request = %vus, want at least the 20ms wait", min)
+	}
+}
@@ -0,0 +1,36 @@
+package loader
+
+import (
+	"runtime"
+	"sync/atomic"
+)
+
+// WithEpoll runs the raw engine on a few epoll event loops instead of a goroutine per connection, each loop driving
+// its share of the goroutines (connections). loops below 1 makes a loop per CPU (GOMAXPROCS). Linux only.
+func WithEpoll(loops int) Option {
+	return func(cfg *LoadCfg) {
+		if loops < 1 {
+			loops = runtime.GOMAXPROCS(0)
+		}
+		cfg.epollLoops = loops
+	}
+}
+
+// Sessions the number of RunSingleLoadSession calls the load is made of, a session per goroutine or per event loop
+func (cfg *LoadCfg) Sessions() int {
+	if cfg.epollLoops > 0 && cfg.epollLoops < cfg.goroutines {
+		return cfg.epollLoops
+	}
+	return cfg.goroutines
+}
+
+// epollConns the number of connections of the next event loop
+func (cfg *LoadCfg) epollConns() int {
+	loops := cfg.Sessions()
+	i := int(atomic.AddUint32(&cfg.nextLoop, 1)-1) % loops
+	n := cfg.goroutines / loops
+	if i < cfg.goroutines%loops {
+		n++
+	}
+	return n
+}
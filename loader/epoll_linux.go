@@ -0,0 +1,353 @@
+package loader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const epollSupported = true
+
+const (
+	epollET             = 1 << 31 // EPOLLET, which syscall defines as a negative int
+	ipBindAddressNoPort = 24      // IP_BIND_ADDRESS_NO_PORT, the port of a bound socket is chosen by connect
+	epollTick           = 50 * time.Millisecond
+)
+
+// the states of an epollConn
+const (
+	epollConnecting = iota
+	epollWriting
+	epollReading
+)
+
+type epollConn struct {
+	fd       int
+	state    int
+	reused   bool
+	written  int
+	start    time.Time // of the current request, including the connect of a new connection
+	deadline time.Time
+	parser   rawParser
+}
+
+// epollLoop an event loop driving a share of the connections, each making requests one after the other as the
+// session of a goroutine does
+type epollLoop struct {
+	cfg     *LoadCfg
+	stats   *RequesterStats
+	epfd    int
+	wire    []byte
+	head    bool
+	timeout time.Duration
+	targets []syscall.Sockaddr // the connections are made to the targets round robin
+	addrs   []string           // the targets as host:port, for choosing the local addresses
+	next    int
+	conns   map[int32]*epollConn
+	missing int // connections to open
+	buf     []byte
+}
+
+// runEpollSession the RunSingleLoadSession loop of an event loop
+func (cfg *LoadCfg) runEpollSession(stats *RequesterStats, start time.Time) {
+	n := cfg.epollConns()
+	l := &epollLoop{cfg: cfg, stats: stats, head: cfg.method == "HEAD", timeout: time.Millisecond * time.Duration(cfg.timeoutms),
+		conns: make(map[int32]*epollConn, n), missing: n, buf: make([]byte, 64*1024)}
+	fail := func(err error) {
+		stats.recordError(err)
+	}
+	var err error
+	if _, l.wire, err = cfg.raw.request(cfg); err != nil {
+		fail(err)
+		return
+	}
+	if err = l.resolveTargets(); err != nil {
+		fail(err)
+		return
+	}
+	if l.epfd, err = syscall.EpollCreate1(syscall.EPOLL_CLOEXEC); err != nil {
+		fail(err)
+		return
+	}
+	defer l.closeAll()
+
+	events := make([]syscall.EpollEvent, 1024)
+	lastSweep := time.Now()
+	for !cfg.done(start) {
+		stats.tick()
+		for l.missing > 0 {
+			if err = l.open(); err != nil {
+				// retried on the next tick
+				fail(err)
+				break
+			}
+			l.missing--
+		}
+		k, err := syscall.EpollWait(l.epfd, events, int(epollTick/time.Millisecond))
+		if err != nil && err != syscall.EINTR {
+			fail(err)
+			return
+		}
+		for _, ev := range events[:max(k, 0)] {
+			if c := l.conns[ev.Fd]; c != nil {
+				l.progress(c, ev.Events)
+			}
+		}
+		if now := time.Now(); now.Sub(lastSweep) >= epollTick {
+			l.sweep(now)
+			lastSweep = now
+		}
+	}
+	// the statistics of a session are those of a single goroutine, the time spent is the average of the connections
+	stats.TotDuration /= time.Duration(n)
+}
+
+// resolveTargets the addresses the connections are made to
+func (l *epollLoop) resolveTargets() error {
+	if l.cfg.unixSocket != "" {
+		l.targets = []syscall.Sockaddr{&syscall.SockaddrUnix{Name: l.cfg.unixSocket}}
+		l.addrs = []string{l.cfg.unixSocket}
+		return nil
+	}
+	u, err := url.Parse(l.cfg.testUrl)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" {
+		return fmt.Errorf("the epoll engine requires an http or unix url, got %q", u.Scheme)
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), l.timeout)
+	defer cancel()
+	var hosts []string
+	if l.cfg.resolve != nil {
+		hosts, err = l.cfg.resolve.addrs(ctx, u.Hostname(), port)
+	} else {
+		hosts, err = net.DefaultResolver.LookupHost(ctx, u.Hostname())
+	}
+	if err != nil {
+		return err
+	}
+	for _, h := range hosts {
+		if sa := sockaddr(net.ParseIP(h), portNum); sa != nil {
+			l.targets = append(l.targets, sa)
+			l.addrs = append(l.addrs, net.JoinHostPort(h, port))
+		}
+	}
+	if len(l.targets) == 0 {
+		return fmt.Errorf("no addresses for %v", u.Hostname())
+	}
+	return nil
+}
+
+func sockaddr(ip net.IP, port int) syscall.Sockaddr {
+	if ip4 := ip.To4(); ip4 != nil {
+		sa := &syscall.SockaddrInet4{Port: port}
+		copy(sa.Addr[:], ip4)
+		return sa
+	} else if ip != nil {
+		sa := &syscall.SockaddrInet6{Port: port}
+		copy(sa.Addr[:], ip)
+		return sa
+	}
+	return nil
+}
+
+// open starts connecting a new connection
+func (l *epollLoop) open() error {
+	i := l.next % len(l.targets)
+	l.next++
+	domain := syscall.AF_INET
+	switch l.targets[i].(type) {
+	case *syscall.SockaddrInet6:
+		domain = syscall.AF_INET6
+	case *syscall.SockaddrUnix:
+		domain = syscall.AF_UNIX
+	}
+	fd, err := syscall.Socket(domain, syscall.SOCK_STREAM|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	if domain != syscall.AF_UNIX {
+		// as net.Dialer does
+		syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, syscall.TCP_NODELAY, 1)
+		if l.cfg.bind != nil {
+			err = l.bind(fd, l.addrs[i])
+		}
+	}
+	if err == nil {
+		if err = syscall.Connect(fd, l.targets[i]); err == syscall.EINPROGRESS {
+			err = nil
+		}
+	}
+	if err == nil {
+		err = syscall.EpollCtl(l.epfd, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{
+			Events: syscall.EPOLLIN | syscall.EPOLLOUT | syscall.EPOLLRDHUP | epollET, Fd: int32(fd)})
+	}
+	if err != nil {
+		syscall.Close(fd)
+		if isPortExhaustion(err) {
+			return ErrPortExhaustion
+		}
+		return err
+	}
+	now := time.Now()
+	c := &epollConn{fd: fd, state: epollConnecting, start: now, deadline: now.Add(l.timeout)}
+	c.parser.reset(l.head)
+	l.conns[int32(fd)] = c
+	return nil
+}
+
+// bind binds a socket to the next local address, trying the next port while a port range is in use
+func (l *epollLoop) bind(fd int, remote string) (err error) {
+	for i := 0; i < l.cfg.bind.tries(); i++ {
+		local := l.cfg.bind.localAddr("tcp", remote).(*net.TCPAddr)
+		if local.Port == 0 {
+			// the bound addresses can then share the ports of a local address between several targets
+			syscall.SetsockoptInt(fd, syscall.IPPROTO_IP, ipBindAddressNoPort, 1)
+		}
+		sa := sockaddr(local.IP, local.Port)
+		if sa == nil {
+			return nil
+		}
+		if err = syscall.Bind(fd, sa); !isPortExhaustion(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// progress moves a connection forward as far as it can go without blocking
+func (l *epollLoop) progress(c *epollConn, events uint32) {
+	if c.state == epollConnecting {
+		if events&(syscall.EPOLLOUT|syscall.EPOLLERR|syscall.EPOLLHUP) == 0 {
+			return
+		}
+		errno, err := syscall.GetsockoptInt(c.fd, syscall.SOL_SOCKET, syscall.SO_ERROR)
+		if err == nil && errno != 0 {
+			err = syscall.Errno(errno)
+		}
+		if err != nil {
+			l.fail(c, err)
+			return
+		}
+		l.stats.ConnsOpened++
+		c.state = epollWriting
+	}
+	for {
+		switch c.state {
+		case epollWriting:
+			n, err := syscall.Write(c.fd, l.wire[c.written:])
+			if n > 0 {
+				l.cfg.wire.sent.Add(int64(n))
+			}
+			if err == syscall.EAGAIN {
+				return
+			} else if err == syscall.EINTR {
+				continue
+			} else if err != nil {
+				l.retryOrFail(c, err)
+				return
+			}
+			c.written += n
+			if c.written == len(l.wire) {
+				c.state = epollReading
+			}
+		case epollReading:
+			n, err := syscall.Read(c.fd, l.buf)
+			if n > 0 {
+				l.cfg.wire.received.Add(int64(n))
+			}
+			if err == syscall.EAGAIN {
+				return
+			} else if err == syscall.EINTR {
+				continue
+			}
+			done := false
+			if err == nil && n > 0 {
+				_, done, err = c.parser.feed(l.buf[:n])
+			} else if err == nil {
+				if err = c.parser.eof(io.EOF); err == nil {
+					done = true
+				}
+			}
+			if err != nil {
+				l.retryOrFail(c, err)
+				return
+			}
+			if done && !l.complete(c) {
+				return
+			}
+		}
+	}
+}
+
+// complete records the response of a connection and starts its next request. It returns false when the connection
+// was closed instead.
+func (l *epollLoop) complete(c *epollConn) bool {
+	now := time.Now()
+	l.stats.TotReqSize += int64(len(l.wire))
+	l.stats.recordRaw(&c.parser.resp, now.Sub(c.start), l.cfg.success)
+	if c.parser.resp.close || l.cfg.disableKeepAlive {
+		l.closeConn(c)
+		l.missing++
+		return false
+	}
+	c.reused, c.written, c.state = true, 0, epollWriting
+	c.start, c.deadline = now, now.Add(l.timeout)
+	c.parser.reset(l.head)
+	return true
+}
+
+// retryOrFail replaces a connection that failed. As net/http does, the request is retried on a new connection when
+// the server closed an idle connection before responding.
+func (l *epollLoop) retryOrFail(c *epollConn, err error) {
+	if c.reused && c.parser.resp.size == 0 && len(c.parser.partial) == 0 {
+		l.closeConn(c)
+		l.missing++
+		return
+	}
+	l.fail(c, err)
+}
+
+func (l *epollLoop) fail(c *epollConn, err error) {
+	l.stats.recordError(err)
+	l.closeConn(c)
+	l.missing++
+}
+
+// sweep fails the requests that have timed out
+func (l *epollLoop) sweep(now time.Time) {
+	for _, c := range l.conns {
+		if now.After(c.deadline) && c.state == epollConnecting {
+			l.fail(c, &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded})
+		} else if now.After(c.deadline) {
+			l.fail(c, c.parser.respErr(os.ErrDeadlineExceeded))
+		}
+	}
+}
+
+func (l *epollLoop) closeConn(c *epollConn) {
+	delete(l.conns, int32(c.fd))
+	syscall.Close(c.fd)
+}
+
+func (l *epollLoop) closeAll() {
+	for _, c := range l.conns {
+		l.closeConn(c)
+	}
+	syscall.Close(l.epfd)
+}
@@ -0,0 +1,111 @@
+package loader
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// runSessions runs all the sessions of cfg and merges their statistics
+func runSessions(t *testing.T, cfg *LoadCfg, ch chan *RequesterStats) *RequesterStats {
+	t.Helper()
+	for i := 0; i < cfg.Sessions(); i++ {
+		go cfg.RunSingleLoadSession()
+	}
+	stats := NewRequesterStats(cfg.duration)
+	for i := 0; i < cfg.Sessions(); i++ {
+		stats.Merge(runSessionResult(t, ch))
+	}
+	return stats
+}
+
+func TestRunSingleLoadSession_Epoll(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	for _, noKeepAlive := range []bool{false, true} {
+		const conns = 50
+		ch := make(chan *RequesterStats, conns)
+		cfg := NewLoadCfg(1, conns, ts.URL, "", "GET", "", nil, ch, 1000, true, false, noKeepAlive, false,
+			"", "", "", false, WithEpoll(3))
+		if cfg.Sessions() != 3 {
+			t.Fatalf("Sessions() = %d, want an event loop per session", cfg.Sessions())
+		}
+
+		stats := runSessions(t, cfg, ch)
+
+		if stats.NumRequests < conns || stats.NumErrs != 0 {
+			t.Fatalf("no-ka %v: NumRequests = %d, NumErrs = %d, Errors = %v", noKeepAlive, stats.NumRequests, stats.NumErrs,
+				stats.Errors)
+		}
+		if !noKeepAlive && stats.ConnsOpened != conns {
+			t.Errorf("ConnsOpened = %d, want a kept alive connection per goroutine", stats.ConnsOpened)
+		} else if noKeepAlive && stats.ConnsOpened < stats.NumRequests {
+			t.Errorf("ConnsOpened = %d, want a connection per request (%d)", stats.ConnsOpened, stats.NumRequests)
+		}
+		if stats.Protocols["HTTP/1.1"] != stats.NumRequests || stats.Histogram.TotalCount() != int64(stats.NumRequests) {
+			t.Errorf("Protocols = %v, Histogram count = %d, want %d", stats.Protocols, stats.Histogram.TotalCount(),
+				stats.NumRequests)
+		}
+		// the duration averages the connections of the loops, about the test duration
+		if avg := stats.TotDuration / time.Duration(cfg.Sessions()); avg > 2*time.Second || avg < 500*time.Millisecond {
+			t.Errorf("average TotDuration = %v, want about 1s", avg)
+		}
+	}
+}
+
+func TestRunSingleLoadSession_EpollTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	// accepts the connections but never responds
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	ch := make(chan *RequesterStats, 2)
+	cfg := NewLoadCfg(1, 2, "http://"+l.Addr().String()+"/", "", "GET", "", nil, ch, 200, true, false, false, false,
+		"", "", "", false, WithEpoll(1))
+
+	stats := runSessions(t, cfg, ch)
+
+	if stats.NumRequests != 0 || errorCount(stats, ERR_HEADER_TIMEOUT) < 4 {
+		t.Errorf("NumRequests = %d, Errors = %v, want only timeouts", stats.NumRequests, stats.Errors)
+	}
+}
+
+func TestRunSingleLoadSession_EpollUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wrk.sock")
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	ch := make(chan *RequesterStats, 4)
+	cfg := NewLoadCfg(1, 4, "unix://"+path, "", "GET", "", nil, ch, 1000, true, false, false, false,
+		"", "", "", false, WithEpoll(2))
+
+	stats := runSessions(t, cfg, ch)
+
+	if stats.NumRequests == 0 || stats.NumErrs != 0 {
+		t.Errorf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package loader
+
+import (
+	"errors"
+	"time"
+)
+
+const epollSupported = false
+
+func (cfg *LoadCfg) runEpollSession(stats *RequesterStats, start time.Time) {
+	err := errors.New("the epoll engine is only available on Linux")
+	stats.recordError(err)
+}
@@ -0,0 +1,204 @@
+package loader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tsliwowicz/go-wrk/util"
+)
+
+// the categories of RequesterStats.Errors
+const (
+	ERR_DNS             = "dns failure"
+	ERR_REFUSED         = "connect refused"
+	ERR_CONNECT_TIMEOUT = "connect timeout"
+	ERR_PORT_EXHAUSTION = "port exhaustion"
+	ERR_TLS             = "tls handshake"
+	ERR_HEADER_TIMEOUT  = "response header timeout"
+	ERR_BODY_TIMEOUT    = "body read timeout"
+	ERR_READ_TIMEOUT    = "read timeout"  // a socket read deadline outside of an HTTP response, e.g. a WebSocket reply
+	ERR_WRITE_TIMEOUT   = "write timeout" // a socket write deadline
+	ERR_TIMEOUT         = "request timeout"
+	ERR_RESET           = "connection reset"
+	ERR_EOF             = "eof"
+	ERR_REDIRECT        = "redirect blocked"
+	ERR_STATUS          = "status code"
+	ERR_ASSERTION       = "assertion failure"
+	ERR_GRAPHQL         = "graphql errors"
+	ERR_GRPC            = "grpc status"
+	ERR_OTHER           = "other"
+)
+
+// the distinct messages kept for each category
+const MAX_ERROR_EXAMPLES = 3
+
+// ErrorStats the errors of a category
+type ErrorStats struct {
+	Count    int
+	First    time.Time // when the first one happened
+	Examples []string  // the first distinct messages, up to MAX_ERROR_EXAMPLES
+}
+
+func (e *ErrorStats) String() string {
+	return fmt.Sprintf("%d %q", e.Count, e.Examples)
+}
+
+func (e *ErrorStats) addExample(msg string) {
+	if len(e.Examples) >= MAX_ERROR_EXAMPLES {
+		return
+	}
+	for _, m := range e.Examples {
+		if m == msg {
+			return
+		}
+	}
+	e.Examples = append(e.Examples, msg)
+}
+
+func (e *ErrorStats) merge(o *ErrorStats) {
+	if e.Count == 0 || o.First.Before(e.First) {
+		e.First = o.First
+	}
+	e.Count += o.Count
+	for _, m := range o.Examples {
+		e.addExample(m)
+	}
+}
+
+// bodyError an error reading the body of a response, after its headers were received
+type bodyError struct {
+	err error
+}
+
+func (e *bodyError) Error() string {
+	return e.err.Error()
+}
+
+func (e *bodyError) Unwrap() error {
+	return e.err
+}
+
+// headerError an error waiting for the headers of a response, by the engines that read the responses themselves
+type headerError struct {
+	err error
+}
+
+func (e *headerError) Error() string {
+	return e.err.Error()
+}
+
+func (e *headerError) Unwrap() error {
+	return e.err
+}
+
+// recordError counts a failed request in the category of its error
+func (stats *RequesterStats) recordError(err error) {
+	category := classifyError(err)
+	e, ok := stats.Errors[category]
+	if !ok {
+		e = &ErrorStats{First: time.Now()}
+		stats.Errors[category] = e
+	}
+	e.Count++
+	e.addExample(errorMessage(err))
+	stats.NumErrs++
+}
+
+// errorMessage the message of err, without the method and url net/http adds
+func errorMessage(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// classifyError the category of an error. The causes are checked from the most specific, a refused connection to a
+// proxy is a refused connection whatever the url, and the errors no category describes are ERR_OTHER.
+func classifyError(err error) string {
+	var statusErr *StatusError
+	var assertErr *AssertionError
+	var redirectErr *util.RedirectError
+	var graphqlErr *GraphQLError
+	var grpcErr *GRPCError
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var body *bodyError
+	var header *headerError
+	switch {
+	case errors.As(err, &statusErr):
+		return ERR_STATUS
+	case errors.As(err, &assertErr):
+		return ERR_ASSERTION
+	case errors.As(err, &redirectErr):
+		return ERR_REDIRECT
+	case errors.As(err, &graphqlErr):
+		return ERR_GRAPHQL
+	case errors.As(err, &grpcErr):
+		return ERR_GRPC
+	case errors.Is(err, ErrPortExhaustion):
+		return ERR_PORT_EXHAUSTION
+	case errors.As(err, &dnsErr):
+		return ERR_DNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ERR_REFUSED
+	case errors.As(err, &opErr) && opErr.Op == "dial" && isTimeout(err):
+		return ERR_CONNECT_TIMEOUT
+	case isTLSError(err):
+		return ERR_TLS
+	case errors.As(err, &body) && isAnyTimeout(err):
+		return ERR_BODY_TIMEOUT
+	case (errors.As(err, &header) || isAwaitingHeaders(err)) && isAnyTimeout(err):
+		return ERR_HEADER_TIMEOUT
+	case errors.As(err, &opErr) && opErr.Op == "read" && isAnyTimeout(err):
+		return ERR_READ_TIMEOUT
+	case errors.As(err, &opErr) && opErr.Op == "write" && isAnyTimeout(err):
+		return ERR_WRITE_TIMEOUT
+	case isAnyTimeout(err):
+		return ERR_TIMEOUT
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return ERR_RESET
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ERR_EOF
+	}
+	return ERR_OTHER
+}
+
+// isAnyTimeout true for the timeouts of the connections, of the requests and of their contexts
+func isAnyTimeout(err error) bool {
+	return isTimeout(err) || errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// isAwaitingHeaders true for the errors net/http returns when the response headers did not arrive in time, the
+// ResponseHeaderTimeout of the HTTP/1.1 and HTTP/2 transports and the http.Client timeout
+func isAwaitingHeaders(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "awaiting response headers") || strings.Contains(msg, "while awaiting headers")
+}
+
+// isTLSError true for the failures of a TLS handshake: alerts, certificates that could not be verified and the
+// crypto/tls errors that have no type of their own
+func isTLSError(err error) bool {
+	var alert tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &alert) || errors.As(err, &recordErr) || errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) || errors.As(err, &invalidErr) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "tls: ") || strings.Contains(msg, "TLS handshake")
+}
@@ -0,0 +1,135 @@
+package loader
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tsliwowicz/go-wrk/util"
+)
+
+// errorCount the number of errors of a category
+func errorCount(stats *RequesterStats, category string) int {
+	if e := stats.Errors[category]; e != nil {
+		return e.Count
+	}
+	return 0
+}
+
+// timeoutErr a net.Error timeout with the message of the net/http ones
+type timeoutErr struct {
+	msg string
+}
+
+func (e *timeoutErr) Error() string   { return e.msg }
+func (e *timeoutErr) Timeout() bool   { return true }
+func (e *timeoutErr) Temporary() bool { return true }
+
+func TestClassifyError(t *testing.T) {
+	get := func(err error) error {
+		return &url.Error{Op: "Get", URL: "http://host/", Err: err}
+	}
+	dial := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: err}
+	}
+	read := func(err error) error {
+		return &net.OpError{Op: "read", Net: "tcp", Err: err}
+	}
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{get(&net.DNSError{Err: "no such host", Name: "host", IsNotFound: true}), ERR_DNS},
+		{get(dial(&os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED})), ERR_REFUSED},
+		{syscall.ECONNREFUSED, ERR_REFUSED},
+		{get(dial(os.ErrDeadlineExceeded)), ERR_CONNECT_TIMEOUT},
+		{get(ErrPortExhaustion), ERR_PORT_EXHAUSTION},
+		{get(x509.UnknownAuthorityError{}), ERR_TLS},
+		{get(errors.New("net/http: TLS handshake timeout")), ERR_TLS},
+		{get(errors.New("remote error: tls: handshake failure")), ERR_TLS},
+		{get(&timeoutErr{"net/http: timeout awaiting response headers"}), ERR_HEADER_TIMEOUT},
+		{get(&timeoutErr{"http2: timeout awaiting response headers"}), ERR_HEADER_TIMEOUT},
+		{get(&timeoutErr{"context deadline exceeded (Client.Timeout exceeded while awaiting headers)"}), ERR_HEADER_TIMEOUT},
+		{&headerError{read(os.ErrDeadlineExceeded)}, ERR_HEADER_TIMEOUT},
+		{&headerError{io.EOF}, ERR_EOF},
+		{get(read(os.ErrDeadlineExceeded)), ERR_READ_TIMEOUT},
+		{&net.OpError{Op: "write", Net: "tcp", Err: os.ErrDeadlineExceeded}, ERR_WRITE_TIMEOUT},
+		{os.ErrDeadlineExceeded, ERR_TIMEOUT},
+		{get(context.DeadlineExceeded), ERR_TIMEOUT},
+		{&bodyError{read(os.ErrDeadlineExceeded)}, ERR_BODY_TIMEOUT},
+		{&bodyError{io.ErrUnexpectedEOF}, ERR_EOF},
+		{get(read(&os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET})), ERR_RESET},
+		{get(io.EOF), ERR_EOF},
+		{get(util.NewRedirectError("redirection not allowed")), ERR_REDIRECT},
+		{&StatusError{Code: 503}, ERR_STATUS},
+		{&AssertionError{Check: "contains:ok"}, ERR_ASSERTION},
+		{&GraphQLError{msg: "graphql response contains errors"}, ERR_GRAPHQL},
+		{errors.New("websocket: bad handshake"), ERR_OTHER},
+	} {
+		if got := classifyError(tc.err); got != tc.want {
+			t.Errorf("classifyError(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestRequesterStats_RecordError(t *testing.T) {
+	stats := NewRequesterStats(1)
+	for i := 0; i < 5; i++ {
+		stats.recordError(fmt.Errorf("dial tcp 10.0.0.%d:80: %w", i%4, syscall.ECONNREFUSED))
+	}
+	stats.recordError(&StatusError{Code: http.StatusInternalServerError})
+	e := stats.Errors[ERR_REFUSED]
+	if stats.NumErrs != 6 || len(stats.Errors) != 2 || e == nil || e.Count != 5 || e.First.IsZero() {
+		t.Fatalf("NumErrs = %d, Errors = %v", stats.NumErrs, stats.Errors)
+	}
+	if len(e.Examples) != MAX_ERROR_EXAMPLES || e.Examples[0] != "dial tcp 10.0.0.0:80: connection refused" {
+		t.Errorf("Examples = %q, want the first %d distinct messages", e.Examples, MAX_ERROR_EXAMPLES)
+	}
+
+	// the merged examples are still distinct, and the first error is the earliest of both
+	earlier := NewRequesterStats(1)
+	earlier.recordError(fmt.Errorf("dial tcp 10.0.0.0:80: %w", syscall.ECONNREFUSED))
+	earlier.Errors[ERR_REFUSED].First = e.First.Add(-time.Second)
+	agg := NewRequesterStats(1)
+	agg.Merge(stats)
+	agg.Merge(earlier)
+	m := agg.Errors[ERR_REFUSED]
+	if m.Count != 6 || !m.First.Equal(e.First.Add(-time.Second)) || len(m.Examples) != MAX_ERROR_EXAMPLES {
+		t.Errorf("merged %v, first at %v", m, m.First)
+	}
+	if errorCount(agg, ERR_STATUS) != 1 || agg.NumErrs != 7 {
+		t.Errorf("merged Errors = %v, NumErrs = %d", agg.Errors, agg.NumErrs)
+	}
+}
+
+func TestRunSingleLoadSession_RedirectBlocked(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/next", http.StatusFound)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, false, false, false, false, "", "", "", false)
+	stats := runSession(t, cfg, ch)
+	if stats.NumRequests != 0 || stats.NumErrs == 0 || errorCount(stats, ERR_REDIRECT) != stats.NumErrs {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v, want only blocked redirects", stats.NumRequests,
+			stats.NumErrs, stats.Errors)
+	}
+	if e := stats.Errors[ERR_REDIRECT]; e.Examples[0] != "redirection not allowed" {
+		t.Errorf("Examples = %q", e.Examples)
+	}
+}
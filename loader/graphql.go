@@ -0,0 +1,170 @@
+package loader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+const ANONYMOUS_OPERATION = "<anonymous>"
+
+var operationRe = regexp.MustCompile(`\b(query|mutation|subscription)\s+([_A-Za-z][_0-9A-Za-z]*)`)
+
+// GraphQLCfg a GraphQL workload - a query document and an optional variables template that are sent as a JSON POST.
+// When the document holds several named operations (and none was selected) the requests cycle through all of them.
+type GraphQLCfg struct {
+	query      string
+	variables  *template.Template
+	operations []string
+	header     map[string]string
+	seq        int64
+}
+
+// graphQLVars the data available to the variables template
+type graphQLVars struct {
+	Seq  int64 // sequence number of the request, unique across all goroutines
+	Unix int64 // current time in seconds
+}
+
+type graphQLRequest struct {
+	Query         string          `json:"query"`
+	OperationName string          `json:"operationName,omitempty"`
+	Variables     json.RawMessage `json:"variables,omitempty"`
+}
+
+// GraphQLError returned when a response carries a non empty "errors" array
+type GraphQLError struct {
+	msg string
+}
+
+func (self *GraphQLError) Error() string {
+	return self.msg
+}
+
+// NewGraphQLCfg validates the query document and variables template.
+// variables may be empty, operation may be empty to use all the operations found in the document.
+func NewGraphQLCfg(query, variables, operation string) (*GraphQLCfg, error) {
+	if strings.TrimSpace(query) == "" {
+		return nil, fmt.Errorf("graphql query document is empty")
+	}
+	g := &GraphQLCfg{query: query}
+
+	if operation != "" {
+		g.operations = []string{operation}
+	} else {
+		g.operations = operationNames(query)
+	}
+
+	if strings.TrimSpace(variables) != "" {
+		tmpl, err := template.New("variables").Funcs(template.FuncMap{
+			"randInt": func(min, max int) int { return min + rand.Intn(max-min+1) },
+		}).Parse(variables)
+		if err != nil {
+			return nil, fmt.Errorf("invalid graphql variables template: %v", err)
+		}
+		g.variables = tmpl
+		if _, err = g.renderVariables(0); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// WithGraphQL sends every request as a GraphQL POST built from g instead of the plain method and body
+func WithGraphQL(g *GraphQLCfg) Option {
+	return func(cfg *LoadCfg) {
+		g.header = map[string]string{"Content-Type": "application/json"}
+		for k, v := range cfg.header {
+			g.header[k] = v
+		}
+		cfg.graphql = g
+	}
+}
+
+// operationNames returns the names of the operations defined in a query document, in order
+func operationNames(query string) []string {
+	var stripped strings.Builder
+	for _, line := range strings.Split(query, "\n") {
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		stripped.WriteString(line)
+		stripped.WriteString("\n")
+	}
+
+	var names []string
+	for _, m := range operationRe.FindAllStringSubmatch(stripped.String(), -1) {
+		names = append(names, m[2])
+	}
+	return names
+}
+
+func (g *GraphQLCfg) renderVariables(seq int64) (json.RawMessage, error) {
+	if g.variables == nil {
+		return nil, nil
+	}
+	var buf bytes.Buffer
+	if err := g.variables.Execute(&buf, graphQLVars{Seq: seq, Unix: time.Now().Unix()}); err != nil {
+		return nil, fmt.Errorf("graphql variables template failed: %v", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("graphql variables are not valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// nextBody returns the operation name and the JSON body of the next request
+func (g *GraphQLCfg) nextBody() (string, string, error) {
+	seq := atomic.AddInt64(&g.seq, 1) - 1
+	req := graphQLRequest{Query: g.query}
+	op := ANONYMOUS_OPERATION
+	if len(g.operations) > 0 {
+		op = g.operations[int(seq%int64(len(g.operations)))]
+		// a single operation document does not require the name, but sending it is harmless
+		req.OperationName = op
+	}
+
+	vars, err := g.renderVariables(seq)
+	if err != nil {
+		return op, "", err
+	}
+	req.Variables = vars
+
+	body, err := json.Marshal(&req)
+	if err != nil {
+		return op, "", err
+	}
+	return op, string(body), nil
+}
+
+// doRequest sends a single GraphQL request. Returns the operation name along with the DoRequest results
+func (g *GraphQLCfg) doRequest(httpClient *http.Client, host, loadUrl string, success *SuccessCfg, res *reqResult) (op string, respSize int, duration time.Duration, err error) {
+	op, body, err := g.nextBody()
+	if err != nil {
+		return op, 0, 0, err
+	}
+	respSize, duration, err = doRequest(httpClient, g.header, http.MethodPost, host, loadUrl, body, nil, success, checkGraphQLResponse, res)
+	return
+}
+
+// checkGraphQLResponse fails responses that report errors, GraphQL servers usually return those with a 200
+func checkGraphQLResponse(resp *http.Response, body []byte) error {
+	var res struct {
+		Errors []json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil {
+		return &GraphQLError{msg: "invalid graphql response"}
+	}
+	if len(res.Errors) > 0 {
+		return &GraphQLError{msg: "graphql response contains errors"}
+	}
+	return nil
+}
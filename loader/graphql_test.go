@@ -0,0 +1,125 @@
+package loader
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestOperationNames(t *testing.T) {
+	cases := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"anonymous", "{ me { id } }", nil},
+		{"single", "query Me { me { id } }", []string{"Me"}},
+		{"multiple", "query A { a }\nmutation B($x: Int) { b(x: $x) }", []string{"A", "B"}},
+		{"comment_ignored", "# query Old { x }\nquery New { y }", []string{"New"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := operationNames(tc.query); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("operationNames(%q) = %v, want %v", tc.query, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewGraphQLCfg_Errors(t *testing.T) {
+	if _, err := NewGraphQLCfg("  ", "", ""); err == nil {
+		t.Error("want err for empty query, got nil")
+	}
+	if _, err := NewGraphQLCfg("{ a }", "{{.Nope", ""); err == nil {
+		t.Error("want err for bad template, got nil")
+	}
+	if _, err := NewGraphQLCfg("{ a }", "{not json", ""); err == nil {
+		t.Error("want err for invalid JSON variables, got nil")
+	}
+}
+
+func TestGraphQLCfg_NextBody(t *testing.T) {
+	g, err := NewGraphQLCfg("query A { a }\nquery B { b }", `{"id": {{.Seq}}}`, "")
+	if err != nil {
+		t.Fatalf("NewGraphQLCfg err = %v", err)
+	}
+
+	for i, wantOp := range []string{"A", "B", "A"} {
+		op, body, err := g.nextBody()
+		if err != nil {
+			t.Fatalf("nextBody err = %v", err)
+		}
+		if op != wantOp {
+			t.Errorf("op = %q, want %q", op, wantOp)
+		}
+		var req struct {
+			OperationName string
+			Variables     struct{ Id int }
+		}
+		if err := json.Unmarshal([]byte(body), &req); err != nil {
+			t.Fatalf("body %q is not JSON: %v", body, err)
+		}
+		if req.OperationName != wantOp || req.Variables.Id != i {
+			t.Errorf("body = %s, want operationName %q and id %d", body, wantOp, i)
+		}
+	}
+}
+
+func TestCheckGraphQLResponse(t *testing.T) {
+	var gqlErr *GraphQLError
+	if err := checkGraphQLResponse(nil, []byte(`{"data":{"a":1}}`)); err != nil {
+		t.Errorf("data only: err = %v, want nil", err)
+	}
+	if err := checkGraphQLResponse(nil, []byte(`{"data":null,"errors":[]}`)); err != nil {
+		t.Errorf("empty errors: err = %v, want nil", err)
+	}
+	if err := checkGraphQLResponse(nil, []byte(`{"errors":[{"message":"boom"}]}`)); !errors.As(err, &gqlErr) {
+		t.Errorf("errors present: err = %v, want *GraphQLError", err)
+	}
+	if err := checkGraphQLResponse(nil, []byte(`<html>`)); !errors.As(err, &gqlErr) {
+		t.Errorf("not JSON: err = %v, want *GraphQLError", err)
+	}
+}
+
+func TestRunSingleLoadSession_GraphQL(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("got %s with Content-Type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+		}
+		var req graphQLRequest
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		w.WriteHeader(http.StatusOK)
+		if req.OperationName == "Bad" {
+			_, _ = w.Write([]byte(`{"data":null,"errors":[{"message":"nope"}]}`))
+		} else {
+			_, _ = w.Write([]byte(`{"data":{"ok":true}}`))
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	g, err := NewGraphQLCfg("query Good { ok }\nquery Bad { ok }", "", "")
+	if err != nil {
+		t.Fatalf("NewGraphQLCfg err = %v", err)
+	}
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false, WithGraphQL(g))
+
+	stats := runSession(t, cfg, ch)
+
+	good, bad := stats.Operations["Good"], stats.Operations["Bad"]
+	if good == nil || bad == nil {
+		t.Fatalf("Operations = %v, want Good and Bad", stats.Operations)
+	}
+	if good.NumRequests == 0 || good.NumErrs != 0 {
+		t.Errorf("Good: NumRequests = %d, NumErrs = %d", good.NumRequests, good.NumErrs)
+	}
+	if bad.NumRequests != 0 || bad.NumErrs == 0 {
+		t.Errorf("Bad: NumRequests = %d, NumErrs = %d", bad.NumRequests, bad.NumErrs)
+	}
+	if stats.NumRequests != good.NumRequests || stats.NumErrs != bad.NumErrs {
+		t.Errorf("totals %d/%d do not match the operations", stats.NumRequests, stats.NumErrs)
+	}
+}
@@ -0,0 +1,149 @@
+package loader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gRPC status code names, indexed by code
+var grpcCodes = []string{"OK", "CANCELLED", "UNKNOWN", "INVALID_ARGUMENT", "DEADLINE_EXCEEDED", "NOT_FOUND",
+	"ALREADY_EXISTS", "PERMISSION_DENIED", "RESOURCE_EXHAUSTED", "FAILED_PRECONDITION", "ABORTED", "OUT_OF_RANGE",
+	"UNIMPLEMENTED", "INTERNAL", "UNAVAILABLE", "DATA_LOSS", "UNAUTHENTICATED"}
+
+// GRPCCfg a gRPC workload - a method and the serialized request messages sent on every call.
+// A single message makes a unary call (or a server streaming one), several messages are sent as a client stream.
+type GRPCCfg struct {
+	method string
+	body   string
+	msgs   int
+	header map[string]string
+}
+
+// GRPCStats gRPC specific statistics
+type GRPCStats struct {
+	Status   map[string]int // calls by grpc-status code name
+	MsgsSent int
+	MsgsRecv int
+}
+
+// GRPCError a call that ended with a grpc-status other than OK
+type GRPCError struct {
+	Code    int
+	Message string
+}
+
+func (self *GRPCError) Error() string {
+	return "grpc status " + grpcCodeName(self.Code)
+}
+
+// NewGRPCCfg method is package.Service/Method, messages are serialized protobuf messages
+func NewGRPCCfg(method string, messages [][]byte) (*GRPCCfg, error) {
+	method = strings.TrimPrefix(method, "/")
+	if i := strings.Index(method, "/"); i <= 0 || i == len(method)-1 || strings.Count(method, "/") != 1 {
+		return nil, fmt.Errorf("invalid grpc method %q, expected package.Service/Method", method)
+	}
+	if len(messages) == 0 {
+		return nil, errors.New("grpc call requires at least one request message")
+	}
+	var body []byte
+	for _, msg := range messages {
+		body = appendGRPCFrame(body, msg)
+	}
+	return &GRPCCfg{method: method, body: string(body), msgs: len(messages)}, nil
+}
+
+// WithGRPC sends every request as a gRPC call. Plain http urls, as the default one of a unix socket, use cleartext
+// HTTP/2 unless a protocol was selected.
+func WithGRPC(g *GRPCCfg) Option {
+	return func(cfg *LoadCfg) {
+		g.header = map[string]string{"Content-Type": "application/grpc", "TE": "trailers",
+			"Grpc-Timeout": fmt.Sprintf("%dm", cfg.timeoutms)}
+		for k, v := range cfg.header {
+			g.header[k] = v
+		}
+		cfg.grpc = g
+	}
+}
+
+func grpcCodeName(code int) string {
+	if code >= 0 && code < len(grpcCodes) {
+		return grpcCodes[code]
+	}
+	return strconv.Itoa(code)
+}
+
+func newGRPCStats() *GRPCStats {
+	return &GRPCStats{Status: make(map[string]int)}
+}
+
+func (g *GRPCStats) merge(o *GRPCStats) {
+	for k, v := range o.Status {
+		g.Status[k] += v
+	}
+	g.MsgsSent += o.MsgsSent
+	g.MsgsRecv += o.MsgsRecv
+}
+
+// appendGRPCFrame appends a length prefixed, uncompressed message
+func appendGRPCFrame(buf []byte, msg []byte) []byte {
+	buf = append(buf, 0)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(msg)))
+	return append(buf, msg...)
+}
+
+// countGRPCFrames the number of messages in a response body
+func countGRPCFrames(body []byte) (int, error) {
+	n := 0
+	for len(body) > 0 {
+		if len(body) < 5 {
+			return n, errors.New("truncated grpc message")
+		}
+		l := binary.BigEndian.Uint32(body[1:5])
+		if uint64(len(body)-5) < uint64(l) {
+			return n, errors.New("truncated grpc message")
+		}
+		body = body[5+l:]
+		n++
+	}
+	return n, nil
+}
+
+// checkResponse judges the call by its grpc-status (in the trailers, or in the headers of a trailers-only response)
+func (g *GRPCCfg) checkResponse(stats *GRPCStats) bodyValidator {
+	return func(resp *http.Response, body []byte) error {
+		status := resp.Trailer.Get("Grpc-Status")
+		msg := resp.Trailer.Get("Grpc-Message")
+		if status == "" {
+			status = resp.Header.Get("Grpc-Status")
+			msg = resp.Header.Get("Grpc-Message")
+		}
+		if status == "" {
+			stats.Status["missing grpc-status"]++
+			return errors.New("missing grpc-status")
+		}
+		code, err := strconv.Atoi(status)
+		if err != nil {
+			code = 2 // UNKNOWN
+		}
+		stats.Status[grpcCodeName(code)]++
+
+		n, err := countGRPCFrames(body)
+		stats.MsgsRecv += n
+		if code != 0 {
+			return &GRPCError{Code: code, Message: msg}
+		}
+		return err
+	}
+}
+
+// doRequest makes a single call, the results are the same as DoRequest's
+func (g *GRPCCfg) doRequest(httpClient *http.Client, host, baseUrl string, success *SuccessCfg, stats *GRPCStats, res *reqResult) (respSize int, duration time.Duration, err error) {
+	callUrl := strings.TrimRight(baseUrl, "/") + "/" + g.method
+	stats.MsgsSent += g.msgs
+	return doRequest(httpClient, g.header, http.MethodPost, host, callUrl, g.body, nil, success, g.checkResponse(stats), res)
+}
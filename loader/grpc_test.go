@@ -0,0 +1,134 @@
+package loader
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// newGRPCTestServer a cleartext HTTP/2 server that answers every request message with one reply message.
+// Calls to a method named Fail end with UNAVAILABLE.
+func newGRPCTestServer(t *testing.T) string {
+	t.Helper()
+	ts := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.Header.Get("Content-Type") != "application/grpc" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		n, _ := countGRPCFrames(body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		var reply []byte
+		for i := 0; i < n; i++ {
+			reply = appendGRPCFrame(reply, []byte("ok"))
+		}
+		_, _ = w.Write(reply)
+		if strings.HasSuffix(r.URL.Path, "/Fail") {
+			w.Header().Set("Grpc-Status", "14")
+			w.Header().Set("Grpc-Message", "try later")
+			return
+		}
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+func TestNewGRPCCfg(t *testing.T) {
+	g, err := NewGRPCCfg("/test.Svc/Call", [][]byte{[]byte("a"), []byte("bc")})
+	if err != nil {
+		t.Fatalf("NewGRPCCfg err = %v", err)
+	}
+	if g.method != "test.Svc/Call" || g.msgs != 2 {
+		t.Errorf("method = %q, msgs = %d", g.method, g.msgs)
+	}
+	if want := "\x00\x00\x00\x00\x01a\x00\x00\x00\x00\x02bc"; g.body != want {
+		t.Errorf("body = %q, want %q", g.body, want)
+	}
+	for _, m := range []string{"", "test.Svc", "test.Svc/", "/Call", "a/b/c"} {
+		if _, err = NewGRPCCfg(m, [][]byte{nil}); err == nil {
+			t.Errorf("NewGRPCCfg(%q) want err, got nil", m)
+		}
+	}
+	if _, err = NewGRPCCfg("test.Svc/Call", nil); err == nil {
+		t.Error("want err without messages, got nil")
+	}
+}
+
+func TestCountGRPCFrames(t *testing.T) {
+	body := appendGRPCFrame(appendGRPCFrame(nil, []byte("x")), nil)
+	if n, err := countGRPCFrames(body); n != 2 || err != nil {
+		t.Errorf("countGRPCFrames = %d, %v, want 2, nil", n, err)
+	}
+	if _, err := countGRPCFrames(body[:len(body)-1]); err == nil {
+		t.Error("want err for a truncated message, got nil")
+	}
+}
+
+func TestRunSingleLoadSession_GRPC(t *testing.T) {
+	u := newGRPCTestServer(t)
+	cases := []struct {
+		method string
+		status string
+		errs   bool
+	}{
+		{"test.Svc/Call", "OK", false},
+		{"test.Svc/Fail", "UNAVAILABLE", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.status, func(t *testing.T) {
+			g, _ := NewGRPCCfg(tc.method, [][]byte{[]byte("a"), []byte("b")})
+			ch := make(chan *RequesterStats, 1)
+			cfg := NewLoadCfg(1, 1, u, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false, WithGRPC(g))
+
+			stats := runSession(t, cfg, ch)
+
+			if stats.GRPC == nil {
+				t.Fatal("GRPC stats = nil")
+			}
+			calls := stats.GRPC.Status[tc.status]
+			if calls == 0 || len(stats.GRPC.Status) != 1 {
+				t.Fatalf("Status = %v, want only %v", stats.GRPC.Status, tc.status)
+			}
+			if stats.Protocols["HTTP/2.0"] != calls {
+				t.Errorf("Protocols = %v, want cleartext HTTP/2", stats.Protocols)
+			}
+			if stats.GRPC.MsgsSent != 2*calls || stats.GRPC.MsgsRecv != 2*calls {
+				t.Errorf("sent %d, received %d, want %d", stats.GRPC.MsgsSent, stats.GRPC.MsgsRecv, 2*calls)
+			}
+			if tc.errs {
+				if stats.NumErrs != calls || errorCount(stats, ERR_GRPC) != calls {
+					t.Errorf("NumErrs = %d, Errors = %v", stats.NumErrs, stats.Errors)
+				}
+			} else if stats.NumErrs != 0 || stats.NumRequests != calls {
+				t.Errorf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+			}
+		})
+	}
+}
+
+func TestNewLoadCfg_GRPCCleartext(t *testing.T) {
+	g, _ := NewGRPCCfg("test.Svc/Call", [][]byte{[]byte("a")})
+	for _, tc := range []struct {
+		url     string
+		unixUrl string
+		proto   string
+	}{
+		{"http://localhost/", "", PROTO_H2C},
+		{"https://localhost/", "", ""},
+		{"unix:///tmp/grpc.sock", "", PROTO_H2C},
+		{"unix:///tmp/grpc.sock", "https://sidecar/", ""},
+	} {
+		cfg := NewLoadCfg(1, 1, tc.url, "", "GET", "", nil, nil, 1000, true, false, false, false, "", "", "", false,
+			WithGRPC(g), WithUnixUrl(tc.unixUrl))
+		if cfg.proto != tc.proto {
+			t.Errorf("%v %v: proto = %q, want %q", tc.url, tc.unixUrl, cfg.proto, tc.proto)
+		}
+	}
+}
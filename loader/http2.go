@@ -0,0 +1,89 @@
+package loader
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+const (
+	H2_REFUSED_STREAM = "refused stream"
+	H2_GOAWAY         = "goaway"
+	H2_STREAM_RESET   = "stream reset"
+	H2_CONN_ERROR     = "connection error"
+)
+
+// H2Cfg HTTP/2 multiplexing and transport tuning. Zero values keep the http2.Transport defaults.
+type H2Cfg struct {
+	Conns           int  // number of HTTP/2 clients shared by all the goroutines, 0 gives every goroutine its own
+	StrictStreams   bool // never exceed the server's SETTINGS_MAX_CONCURRENT_STREAMS, queue the requests instead
+	MaxFrameSize    uint32
+	HeaderTableSize uint32
+	ConnWindow      int           // initial connection flow control window
+	StreamWindow    int           // initial stream flow control window
+	ReadIdleTimeout time.Duration // send a ping when no frame was received for this long
+	PingTimeout     time.Duration // close the connection when a ping is not answered in time
+}
+
+// WithHTTP2 applies the HTTP/2 tuning to the clients, and shares h.Conns clients between the goroutines
+func WithHTTP2(h H2Cfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.h2 = &h
+	}
+}
+
+func withHTTP2(h *H2Cfg) clientOption {
+	return func(o *clientOpts) {
+		o.h2 = h
+	}
+}
+
+// configure sets the tuning on the transport that handles the HTTP/2 connections
+func (h *H2Cfg) configure(t *http2.Transport) {
+	t.StrictMaxConcurrentStreams = h.StrictStreams
+	t.MaxReadFrameSize = h.MaxFrameSize
+	t.MaxDecoderHeaderTableSize = h.HeaderTableSize
+	t.MaxEncoderHeaderTableSize = h.HeaderTableSize
+	t.ReadIdleTimeout = h.ReadIdleTimeout
+	t.PingTimeout = h.PingTimeout
+}
+
+// http2Config the settings that http2.Transport takes from the http.Transport it is configured on
+func (h *H2Cfg) http2Config() *http.HTTP2Config {
+	return &http.HTTP2Config{
+		MaxReceiveBufferPerConnection: h.ConnWindow,
+		MaxReceiveBufferPerStream:     h.StreamWindow,
+	}
+}
+
+// classifyHTTP2Error returns the HTTP/2 category of err, or an empty string if it is not an HTTP/2 protocol error.
+// Matching is done on the error text since the error types differ between x/net/http2 and the net/http bundle.
+func classifyHTTP2Error(err error) string {
+	var streamErr http2.StreamError
+	if errors.As(err, &streamErr) {
+		if streamErr.Code == http2.ErrCodeRefusedStream {
+			return H2_REFUSED_STREAM
+		}
+		return H2_STREAM_RESET
+	}
+	var connErr http2.ConnectionError
+	if errors.As(err, &connErr) {
+		return H2_CONN_ERROR
+	}
+
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "REFUSED_STREAM"):
+		return H2_REFUSED_STREAM
+	case strings.Contains(msg, "GOAWAY"):
+		return H2_GOAWAY
+	case strings.Contains(msg, "stream error:"):
+		return H2_STREAM_RESET
+	case strings.Contains(msg, "connection error: "):
+		return H2_CONN_ERROR
+	}
+	return ""
+}
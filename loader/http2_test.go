@@ -0,0 +1,153 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+func TestClassifyHTTP2Error(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"refused_stream", http2.StreamError{StreamID: 3, Code: http2.ErrCodeRefusedStream}, H2_REFUSED_STREAM},
+		{"wrapped_refused_stream", fmt.Errorf("Get x: %w", http2.StreamError{StreamID: 3, Code: http2.ErrCodeRefusedStream}), H2_REFUSED_STREAM},
+		{"stream_reset", http2.StreamError{StreamID: 3, Code: http2.ErrCodeCancel}, H2_STREAM_RESET},
+		{"connection_error", http2.ConnectionError(http2.ErrCodeProtocol), H2_CONN_ERROR},
+		{"goaway_text", errors.New("http2: server sent GOAWAY and closed the connection; LastStreamID=1"), H2_GOAWAY},
+		{"refused_text", errors.New("stream error: stream ID 5; REFUSED_STREAM"), H2_REFUSED_STREAM},
+		{"not_http2", errors.New("connection refused"), ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := classifyHTTP2Error(tc.err); got != tc.want {
+				t.Fatalf("classifyHTTP2Error(%v) = %q, want %q", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSessionClient_Shared(t *testing.T) {
+	cfg := NewLoadCfg(1, 4, "http://x", "", "GET", "", nil, nil, 1000, true, false, false, false, "", "", "", true,
+		WithHTTP2(H2Cfg{Conns: 2}))
+
+	seen := make(map[*http.Client]int)
+	for i := 0; i < 4; i++ {
+		c, err := cfg.sessionClient()
+		if err != nil {
+			t.Fatalf("sessionClient err = %v", err)
+		}
+		seen[c]++
+	}
+	if len(seen) != 2 {
+		t.Fatalf("got %d distinct clients, want 2", len(seen))
+	}
+	for _, n := range seen {
+		if n != 2 {
+			t.Errorf("client used by %d sessions, want 2", n)
+		}
+	}
+}
+
+func TestSessionClient_NotShared(t *testing.T) {
+	cfg := NewLoadCfg(1, 2, "http://x", "", "GET", "", nil, nil, 1000, true, false, false, false, "", "", "", true)
+
+	c1, _ := cfg.sessionClient()
+	c2, _ := cfg.sessionClient()
+	if c1 == c2 {
+		t.Fatal("sessions share a client without -h2-conns")
+	}
+}
+
+func TestRunSingleLoadSession_H2cSharedConnection(t *testing.T) {
+	ts := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}), &http2.Server{}))
+	t.Cleanup(ts.Close)
+
+	const sessions = 3
+	ch := make(chan *RequesterStats, sessions)
+	cfg := NewLoadCfg(1, sessions, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", true,
+		WithProto(PROTO_H2C), WithHTTP2(H2Cfg{Conns: 1, MaxFrameSize: 1 << 20, HeaderTableSize: 8192}))
+
+	for i := 0; i < sessions; i++ {
+		go cfg.RunSingleLoadSession()
+	}
+	agg := NewRequesterStats(1)
+	for i := 0; i < sessions; i++ {
+		agg.Merge(runSessionResult(t, ch))
+	}
+
+	if agg.NumRequests == 0 || agg.NumErrs != 0 {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", agg.NumRequests, agg.NumErrs, agg.Errors)
+	}
+	if agg.Protocols["HTTP/2.0"] != agg.NumRequests {
+		t.Errorf("Protocols = %v, want all HTTP/2.0", agg.Protocols)
+	}
+	if agg.ConnsOpened != 1 || agg.H2Conns != 1 {
+		t.Errorf("ConnsOpened = %d, H2Conns = %d, want 1 shared connection", agg.ConnsOpened, agg.H2Conns)
+	}
+}
+
+func TestRunSingleLoadSession_H2cKeepAliveAndTimeout(t *testing.T) {
+	var slowBody atomic.Bool
+	ts := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		if slowBody.Load() {
+			// the headers are in time, only the body is slower than the timeout
+			time.Sleep(150 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}), &http2.Server{}))
+	t.Cleanup(ts.Close)
+
+	for _, tc := range []struct {
+		name string
+		noKA bool
+		slow bool
+	}{
+		{"keep-alive", false, false},
+		{"no-ka", true, false},
+		{"slow body", false, true},
+	} {
+		slowBody.Store(tc.slow)
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 100, true, false, tc.noKA, false, "", "", "", true,
+			WithProto(PROTO_H2C))
+		stats := runSession(t, cfg, ch)
+
+		if stats.NumRequests < 2 || stats.NumErrs != 0 {
+			t.Fatalf("%v: NumRequests = %d, NumErrs = %d, Errors = %v", tc.name, stats.NumRequests, stats.NumErrs,
+				stats.Errors)
+		}
+		if tc.noKA && stats.ConnsOpened != stats.NumRequests || !tc.noKA && stats.ConnsOpened != 1 {
+			t.Errorf("%v: %d connections for %d requests", tc.name, stats.ConnsOpened, stats.NumRequests)
+		}
+	}
+}
+
+func TestClient_H2cHeaderTimeout(t *testing.T) {
+	ts := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}), &http2.Server{}))
+	t.Cleanup(ts.Close)
+
+	c, err := client(true, false, false, 50, true, "", "", "", true, withProto(PROTO_H2C))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = DoRequest(c, nil, "GET", "", ts.URL, "")
+	if got := classifyError(err); got != ERR_HEADER_TIMEOUT {
+		t.Errorf("err = %v, classified %q, want %q", err, got, ERR_HEADER_TIMEOUT)
+	}
+}
@@ -0,0 +1,122 @@
+package loader
+
+import (
+	"sync"
+	"time"
+
+	histo "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// IntervalStats the requests of a single interval of the test, from all the sessions
+type IntervalStats struct {
+	Start       time.Duration // since the start of the test
+	NumRequests int
+	NumErrs     int
+	TotRespSize int64
+	Histogram   *histo.Histogram // the latency of the successful requests, to within 1%
+	// the connections, see ConnTracker. They are counted when they happen, not when the request was sent.
+	ConnsOpened    int
+	ConnsClosed    int // by either side
+	ServerCloses   int // of ConnsClosed, the ones the server closed
+	ConnRequests   int
+	ReusedRequests int // of ConnRequests, the ones sent on a connection that had been used before
+}
+
+// IntervalSeries the statistics of a test by intervals, shared by its sessions. The requests are counted in the
+// interval they were sent in.
+type IntervalSeries struct {
+	Width     time.Duration
+	start     time.Time
+	duration  int
+	mu        sync.Mutex
+	intervals []*IntervalStats
+}
+
+// intervalRecorder the current interval of a session, added to the series when it ends
+type intervalRecorder struct {
+	series      *IntervalSeries
+	index       int
+	hist        *histo.Histogram
+	numRequests int // the session totals when the interval started
+	numErrs     int
+	totRespSize int64
+}
+
+// WithInterval keeps statistics for every interval of width, in RequesterStats.Intervals
+func WithInterval(width time.Duration) Option {
+	return func(cfg *LoadCfg) {
+		cfg.intervals = &IntervalSeries{Width: width, start: time.Now(), duration: cfg.duration}
+	}
+}
+
+// the interval histograms are kept for the whole test, a lower precision keeps them small
+func newIntervalHistogram(duration int) *histo.Histogram {
+	return histo.New(1, int64(duration*1000000), 2)
+}
+
+// Intervals the intervals from the start of the test to the last one with requests
+func (s *IntervalSeries) Intervals() []*IntervalStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*IntervalStats(nil), s.intervals...)
+}
+
+// at the interval i, adding the intervals up to it. The caller holds mu.
+func (s *IntervalSeries) at(i int) *IntervalStats {
+	for len(s.intervals) <= i {
+		s.intervals = append(s.intervals, &IntervalStats{Start: time.Duration(len(s.intervals)) * s.Width,
+			Histogram: newIntervalHistogram(s.duration)})
+	}
+	return s.intervals[i]
+}
+
+func (s *IntervalSeries) index(now time.Time) int {
+	return int(now.Sub(s.start) / s.Width)
+}
+
+func (s *IntervalSeries) recorder() *intervalRecorder {
+	return &intervalRecorder{series: s, index: s.index(time.Now()), hist: newIntervalHistogram(s.duration)}
+}
+
+// flush adds the requests of the session since the interval started to the series
+func (r *intervalRecorder) flush(stats *RequesterStats) {
+	s := r.series
+	s.mu.Lock()
+	i := s.at(r.index)
+	i.NumRequests += stats.NumRequests - r.numRequests
+	i.NumErrs += stats.NumErrs - r.numErrs
+	i.TotRespSize += stats.TotRespSize - r.totRespSize
+	i.Histogram.Merge(r.hist)
+	s.mu.Unlock()
+	r.hist.Reset()
+	r.numRequests, r.numErrs, r.totRespSize = stats.NumRequests, stats.NumErrs, stats.TotRespSize
+}
+
+// tick ends the interval of a session when its time is up. It is called before sending a request.
+func (stats *RequesterStats) tick() {
+	r := stats.interval
+	if r == nil {
+		return
+	}
+	if i := r.series.index(time.Now()); i != r.index {
+		r.flush(stats)
+		r.index = i
+	}
+}
+
+// recordLatency records the latency of a successful request
+func (stats *RequesterStats) recordLatency(d time.Duration) {
+	stats.Histogram.RecordValue(d.Microseconds())
+	if stats.interval != nil {
+		stats.interval.hist.RecordValue(d.Microseconds())
+	}
+}
+
+// report sends the statistics of a session to the aggregator, after adding its last interval to the series
+func (cfg *LoadCfg) report(stats *RequesterStats) {
+	cfg.sessionLabels(stats)
+	if stats.interval != nil {
+		stats.interval.flush(stats)
+	}
+	cfg.statsAggregator <- stats
+}
@@ -0,0 +1,66 @@
+package loader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRunSingleLoadSession_Intervals(t *testing.T) {
+	begin := time.Now()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a brownout from 350 to 650ms
+		if since := time.Since(begin); since > 350*time.Millisecond && since < 650*time.Millisecond {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	const sessions = 3
+	ch := make(chan *RequesterStats, sessions)
+	cfg := NewLoadCfg(1, sessions, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false,
+		WithInterval(100*time.Millisecond))
+	for i := 0; i < sessions; i++ {
+		go cfg.RunSingleLoadSession()
+	}
+	stats := NewRequesterStats(cfg.duration)
+	for i := 0; i < sessions; i++ {
+		stats.Merge(runSessionResult(t, ch))
+	}
+	if stats.Intervals == nil {
+		t.Fatal("Intervals = nil")
+	}
+
+	intervals := stats.Intervals.Intervals()
+	if len(intervals) < 10 || len(intervals) > 11 {
+		t.Fatalf("%d intervals, want 10 for a 1s test", len(intervals))
+	}
+	var requests, errs int
+	var size int64
+	for n, i := range intervals {
+		if i.Start != time.Duration(n)*100*time.Millisecond {
+			t.Errorf("interval %d starts at %v", n, i.Start)
+		}
+		if i.Histogram.TotalCount() != int64(i.NumRequests) {
+			t.Errorf("interval %d: %d latencies for %d requests", n, i.Histogram.TotalCount(), i.NumRequests)
+		}
+		requests += i.NumRequests
+		errs += i.NumErrs
+		size += i.TotRespSize
+		// the intervals clear of the brownout, with a margin
+		if (n <= 2 || (n >= 7 && n <= 9)) && (i.NumErrs != 0 || i.NumRequests == 0) {
+			t.Errorf("interval %d: NumRequests = %d, NumErrs = %d, want only successes", n, i.NumRequests, i.NumErrs)
+		}
+		if (n == 4 || n == 5) && (i.NumErrs == 0 || i.NumRequests != 0) {
+			t.Errorf("interval %d: NumRequests = %d, NumErrs = %d, want only errors", n, i.NumRequests, i.NumErrs)
+		}
+	}
+	// all the sessions reported all their requests
+	if requests != stats.NumRequests || errs != stats.NumErrs || size != stats.TotRespSize {
+		t.Errorf("the intervals add up to %d requests, %d errors and %d bytes, want %d, %d and %d", requests, errs, size,
+			stats.NumRequests, stats.NumErrs, stats.TotRespSize)
+	}
+}
@@ -0,0 +1,74 @@
+package loader
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// path segments that vary from request to request: numbers, UUIDs and long hex strings
+var idSegmentRe = regexp.MustCompile(`^([0-9]+|[0-9a-fA-F]{8}(-[0-9a-fA-F]{4}){3}-[0-9a-fA-F]{12}|[0-9a-fA-F]{16,})$`)
+
+// WithLabel sets the label all the requests are counted under in RequesterStats.Labels. By default every request has
+// its own, see requestLabel.
+func WithLabel(label string) Option {
+	return func(cfg *LoadCfg) {
+		cfg.label = label
+	}
+}
+
+// pathTemplate replaces the segments of a path that look like identifiers with {id}, so that the requests for the
+// same resource share a label
+func pathTemplate(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if idSegmentRe.MatchString(s) {
+			segments[i] = "{id}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// requestLabel the label of a request: the one set by WithLabel, else the GraphQL operation op, the gRPC method, or
+// the method and path template of the url, e.g. GET /users/{id}
+func (cfg *LoadCfg) requestLabel(op string) string {
+	switch {
+	case cfg.label != "":
+		return cfg.label
+	case op != "" && op != ANONYMOUS_OPERATION:
+		return op
+	case cfg.grpc != nil:
+		return cfg.grpc.method
+	}
+	return cfg.pathLabel
+}
+
+// urlLabel the method and path template of the requests to the url
+func (cfg *LoadCfg) urlLabel() string {
+	u, err := url.Parse(cfg.testUrl)
+	if err != nil {
+		return cfg.method
+	}
+	switch {
+	case IsWebSocketUrl(cfg.testUrl), IsSocketUrl(cfg.testUrl):
+		return u.Scheme + "://" + u.Host + pathTemplate(u.Path)
+	case cfg.graphql != nil:
+		return http.MethodPost + " " + pathTemplate(u.Path)
+	}
+	return cfg.method + " " + pathTemplate(u.Path)
+}
+
+// sessionLabels the label statistics of a session whose requests are not recorded one by one, the other engines send
+// a single kind of request
+func (cfg *LoadCfg) sessionLabels(stats *RequesterStats) {
+	if len(stats.Labels) > 0 || stats.NumRequests+stats.NumErrs == 0 {
+		return
+	}
+	g := group(&stats.Labels, cfg.requestLabel(""), cfg.duration)
+	g.NumRequests, g.NumErrs = stats.NumRequests, stats.NumErrs
+	g.Histogram.Merge(stats.Histogram)
+}
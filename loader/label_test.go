@@ -0,0 +1,135 @@
+package loader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPathTemplate(t *testing.T) {
+	for path, want := range map[string]string{
+		"":                                   "/",
+		"/":                                  "/",
+		"/users":                             "/users",
+		"/users/123":                         "/users/{id}",
+		"/users/123/orders/7":                "/users/{id}/orders/{id}",
+		"/cafe/deadbeef":                     "/cafe/deadbeef",
+		"/v1/items/5f1d7c2e9a3b4c0012345678": "/v1/items/{id}",
+		"/docs/3fa85f64-5717-4562-b3fc-2c963f66afa6/content": "/docs/{id}/content",
+	} {
+		if got := pathTemplate(path); got != want {
+			t.Errorf("pathTemplate(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestLoadCfg_RequestLabel(t *testing.T) {
+	call, err := NewGRPCCfg("pkg.Service/Get", [][]byte{nil})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		url    string
+		method string
+		opts   []Option
+		want   string
+	}{
+		{"http://host/users/42?x=1", "GET", nil, "GET /users/{id}"},
+		{"https://host", "DELETE", nil, "DELETE /"},
+		{"http://host/users/42", "GET", []Option{WithLabel("user")}, "user"},
+		{"ws://host:8080/chat", "GET", nil, "ws://host:8080/chat"},
+		{"tcp://host:6379", "GET", nil, "tcp://host:6379/"},
+		{"http://host/", "GET", []Option{WithGRPC(call)}, "pkg.Service/Get"},
+		{"http://host/", "GET", []Option{WithGRPC(call), WithLabel("rpc")}, "rpc"},
+	} {
+		cfg := NewLoadCfg(1, 1, tc.url, "", tc.method, "", nil, nil, 1000, true, false, false, false, "", "", "", false,
+			tc.opts...)
+		if got := cfg.requestLabel(""); got != tc.want {
+			t.Errorf("%v %v: label = %q, want %q", tc.method, tc.url, got, tc.want)
+		}
+	}
+}
+
+func TestRunSingleLoadSession_Labels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		path  string
+		opts  []Option
+		label string
+	}{
+		{"/items/12", nil, "GET /items/{id}"},
+		{"/fail", nil, "GET /fail"},
+		// the raw engine counts the requests of a session under its label
+		{"/items/12", []Option{WithRaw(&RawCfg{})}, "GET /items/{id}"},
+	} {
+		ch := make(chan *RequesterStats, 2)
+		cfg := NewLoadCfg(1, 2, ts.URL+tc.path, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "",
+			false, tc.opts...)
+		go cfg.RunSingleLoadSession()
+		go cfg.RunSingleLoadSession()
+		stats := NewRequesterStats(cfg.duration)
+		stats.Merge(runSessionResult(t, ch))
+		stats.Merge(runSessionResult(t, ch))
+
+		g := stats.Labels[tc.label]
+		if len(stats.Labels) != 1 || g == nil {
+			t.Fatalf("%v: Labels = %v, want only %q", tc.path, stats.Labels, tc.label)
+		}
+		if g.NumRequests != stats.NumRequests || g.NumErrs != stats.NumErrs || g.NumRequests+g.NumErrs == 0 {
+			t.Errorf("%v: %q has %d requests and %d errors, want %d and %d", tc.path, tc.label, g.NumRequests, g.NumErrs,
+				stats.NumRequests, stats.NumErrs)
+		}
+		if g.Histogram.TotalCount() != stats.Histogram.TotalCount() {
+			t.Errorf("%v: %q has %d latencies, want %d", tc.path, tc.label, g.Histogram.TotalCount(),
+				stats.Histogram.TotalCount())
+		}
+	}
+}
+
+func TestRunSingleLoadSession_GraphQLLabels(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"data":{"ok":true}}`))
+	}))
+	defer ts.Close()
+
+	g, err := NewGraphQLCfg("query Users { ok }\nmutation AddUser { ok }", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		opts   []Option
+		labels []string
+	}{
+		// every request is labeled by its operation
+		{[]Option{WithGraphQL(g)}, []string{"Users", "AddUser"}},
+		{[]Option{WithGraphQL(g), WithLabel("api")}, []string{"api"}},
+	} {
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, ts.URL+"/graphql", "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "",
+			false, tc.opts...)
+		stats := runSession(t, cfg, ch)
+
+		if len(stats.Labels) != len(tc.labels) {
+			t.Fatalf("Labels = %v, want %v", stats.Labels, tc.labels)
+		}
+		total := 0
+		for _, label := range tc.labels {
+			l := stats.Labels[label]
+			if l == nil || l.NumRequests == 0 {
+				t.Fatalf("Labels = %v, want requests labeled %q", stats.Labels, label)
+			}
+			total += l.NumRequests
+		}
+		if total != stats.NumRequests {
+			t.Errorf("%v: the labels have %d requests, want %d", tc.labels, total, stats.NumRequests)
+		}
+	}
+}
@@ -2,13 +2,16 @@ package loader
 
 import (
 	"bytes"
+	"crypto/tls"
 	"errors"
-	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -17,7 +20,8 @@ import (
 )
 
 const (
-	USER_AGENT = "go-wrk"
+	USER_AGENT       = "go-wrk"
+	DEFAULT_UNIX_URL = "http://localhost/"
 )
 
 type LoadCfg struct {
@@ -39,16 +43,274 @@ type LoadCfg struct {
 	clientKey          string
 	caCert             string
 	http2              bool
+	graphql            *GraphQLCfg
+	proto              string
+	h2                 *H2Cfg
+	ws                 *WSCfg
+	stream             *StreamCfg
+	grpc               *GRPCCfg
+	socket             *SocketCfg
+	unixSocket         string
+	pipeline           int
+	proxy              *ProxyCfg
+	tls                *TLSCfg
+	resolve            *ResolveCfg
+	bind               *BindCfg
+	raw                *RawCfg
+	epollLoops         int
+	body               *BodyCfg
+	expectContinue     time.Duration
+	success            *SuccessCfg
+	assert             *AssertCfg
+	intervals          *IntervalSeries
+	label              string // of all the requests, empty for a label per request
+	pathLabel          string // the method and path template of the url
+	wire               *WireStats
+	conns              *ConnTracker
+	nextLoop           uint32
+	sharedClients      []*http.Client
+	sharedClientsErr   error
+	sharedClientsOnce  sync.Once
+	nextSharedClient   uint32
+}
+
+// Option configures optional LoadCfg features that are not part of the basic http load
+type Option func(*LoadCfg)
+
+// WithProto selects the HTTP protocol, one of PROTO_AUTO, PROTO_H1, PROTO_H2 or PROTO_H2C
+func WithProto(proto string) Option {
+	return func(cfg *LoadCfg) {
+		cfg.proto = proto
+	}
+}
+
+// WithUnixUrl sets the HTTP url requested over a unix:// target, DEFAULT_UNIX_URL by default.
+// The url host is only the Host header (unless set by host), the connections are made to the socket.
+func WithUnixUrl(u string) Option {
+	return func(cfg *LoadCfg) {
+		if cfg.unixSocket != "" && u != "" {
+			cfg.testUrl = u
+		}
+	}
+}
+
+// IsUnixUrl true for unix:///path/to.sock urls
+func IsUnixUrl(u string) bool {
+	return strings.HasPrefix(u, "unix://")
 }
 
 // RequesterStats used for collecting aggregate statistics
 type RequesterStats struct {
-	TotRespSize    int64
-	TotDuration    time.Duration
-	NumRequests    int
-	NumErrs        int
-	ErrMap		   map[string]int
-	Histogram	   *histo.Histogram
+	TotRespSize     int64
+	TotReqSize      int64 // the request heads and bodies sent, as the application sees them
+	TotDuration     time.Duration
+	NumRequests     int
+	NumErrs         int
+	Errors          map[string]*ErrorStats // the failed requests by the category of their error, see classifyError
+	Histogram       *histo.Histogram
+	StatusCodes     map[int]int            // responses by status code, successful or not
+	FailedHistogram *histo.Histogram       // latency of the responses with a status that is not successful, nil until one
+	Operations      map[string]*GroupStats // GraphQL statistics by operation name
+	Labels          map[string]*GroupStats // statistics by request label, see WithLabel
+	Protocols       map[string]int         // responses by the negotiated protocol, e.g. HTTP/2.0
+	H2Errors        map[string]int         // HTTP/2 protocol errors (refused stream, GOAWAY..), not included in Errors
+	ConnsOpened     int                    // number of new connections the requests were sent on
+	H2Conns         int                    // the new connections that spoke HTTP/2, each carries many requests as streams
+	WebSocket       *WSStats               // nil unless testing a WebSocket url
+	Stream          *StreamStats           // nil unless streaming
+	GRPC            *GRPCStats             // nil unless making gRPC calls
+	Socket          *SocketStats           // nil unless testing a tcp:// or udp:// url
+	Pipeline        *PipelineStats         // nil unless pipelining
+	Proxy           *ProxyStats            // nil unless connecting through a proxy
+	TLS             *TLSStats              // nil until a TLS connection is made
+	DNS             *DNSStats              // nil until a lookup is made, or a connection with WithResolve
+	Continue        *ContinueStats         // nil unless sending Expect: 100-continue
+	Phases          *PhaseStats            // nil until an HTTP response is received by net/http
+	Assertions      *AssertStats           // nil unless checking the responses
+	Intervals       *IntervalSeries        // shared by the sessions of a test, nil unless WithInterval
+	Wire            *WireStats             // shared by the sessions of a test
+	Conns           *ConnTracker           // shared by the sessions of a test
+	interval        *intervalRecorder
+}
+
+// GroupStats statistics for a subset of the requests, e.g. a single GraphQL operation
+type GroupStats struct {
+	NumRequests int
+	NumErrs     int
+	Histogram   *histo.Histogram
+}
+
+func NewRequesterStats(duration int) *RequesterStats {
+	return &RequesterStats{Errors: make(map[string]*ErrorStats), Histogram: newHistogram(duration), Protocols: make(map[string]int),
+		H2Errors: make(map[string]int), StatusCodes: make(map[int]int)}
+}
+
+func newHistogram(duration int) *histo.Histogram {
+	return histo.New(1, int64(duration*1000000), 4)
+}
+
+// emptyLike a new histogram with the same range and precision as h, used for merging
+func emptyLike(h *histo.Histogram) *histo.Histogram {
+	return histo.New(h.LowestTrackableValue(), h.HighestTrackableValue(), int(h.SignificantFigures()))
+}
+
+// group returns the GroupStats for name in groups, creating it on first use
+func group(groups *map[string]*GroupStats, name string, duration int) *GroupStats {
+	if *groups == nil {
+		*groups = make(map[string]*GroupStats)
+	}
+	g, ok := (*groups)[name]
+	if !ok {
+		g = &GroupStats{Histogram: newHistogram(duration)}
+		(*groups)[name] = g
+	}
+	return g
+}
+
+func (g *GroupStats) record(reqDur time.Duration, err error) {
+	if err != nil {
+		g.NumErrs++
+		return
+	}
+	g.NumRequests++
+	g.Histogram.RecordValue(reqDur.Microseconds())
+}
+
+func mergeGroups(dst *map[string]*GroupStats, src map[string]*GroupStats) {
+	if len(src) == 0 {
+		return
+	}
+	if *dst == nil {
+		*dst = make(map[string]*GroupStats)
+	}
+	for k, v := range src {
+		g, ok := (*dst)[k]
+		if !ok {
+			g = &GroupStats{Histogram: emptyLike(v.Histogram)}
+			(*dst)[k] = g
+		}
+		g.NumRequests += v.NumRequests
+		g.NumErrs += v.NumErrs
+		g.Histogram.Merge(v.Histogram)
+	}
+}
+
+// Merge adds the statistics collected by another requester to stats
+func (stats *RequesterStats) Merge(o *RequesterStats) {
+	stats.NumErrs += o.NumErrs
+	stats.NumRequests += o.NumRequests
+	stats.TotRespSize += o.TotRespSize
+	stats.TotReqSize += o.TotReqSize
+	stats.TotDuration += o.TotDuration
+	for k, v := range o.Errors {
+		e, ok := stats.Errors[k]
+		if !ok {
+			e = &ErrorStats{}
+			stats.Errors[k] = e
+		}
+		e.merge(v)
+	}
+	stats.Histogram.Merge(o.Histogram)
+	for k, v := range o.StatusCodes {
+		stats.StatusCodes[k] += v
+	}
+	if o.FailedHistogram != nil {
+		if stats.FailedHistogram == nil {
+			stats.FailedHistogram = emptyLike(o.FailedHistogram)
+		}
+		stats.FailedHistogram.Merge(o.FailedHistogram)
+	}
+	mergeGroups(&stats.Operations, o.Operations)
+	mergeGroups(&stats.Labels, o.Labels)
+	for k, v := range o.Protocols {
+		stats.Protocols[k] += v
+	}
+	for k, v := range o.H2Errors {
+		stats.H2Errors[k] += v
+	}
+	stats.ConnsOpened += o.ConnsOpened
+	stats.H2Conns += o.H2Conns
+	if o.WebSocket != nil {
+		if stats.WebSocket == nil {
+			stats.WebSocket = &WSStats{ConnectHist: emptyLike(o.WebSocket.ConnectHist), LifetimeHist: emptyLike(o.WebSocket.LifetimeHist)}
+		}
+		stats.WebSocket.merge(o.WebSocket)
+	}
+	if o.Stream != nil {
+		if stats.Stream == nil {
+			stats.Stream = &StreamStats{FirstEventHist: emptyLike(o.Stream.FirstEventHist), GapHist: emptyLike(o.Stream.GapHist),
+				DeliveryHist: emptyLike(o.Stream.DeliveryHist)}
+		}
+		stats.Stream.merge(o.Stream)
+	}
+	if o.GRPC != nil {
+		if stats.GRPC == nil {
+			stats.GRPC = newGRPCStats()
+		}
+		stats.GRPC.merge(o.GRPC)
+	}
+	if o.Socket != nil {
+		if stats.Socket == nil {
+			stats.Socket = &SocketStats{ConnectHist: emptyLike(o.Socket.ConnectHist)}
+		}
+		stats.Socket.merge(o.Socket)
+	}
+	if o.Pipeline != nil {
+		if stats.Pipeline == nil {
+			stats.Pipeline = &PipelineStats{}
+		}
+		stats.Pipeline.merge(o.Pipeline)
+	}
+	if o.Proxy != nil {
+		if stats.Proxy == nil {
+			stats.Proxy = &ProxyStats{ConnectHist: emptyLike(o.Proxy.ConnectHist)}
+		}
+		stats.Proxy.merge(o.Proxy)
+	}
+	if o.TLS != nil {
+		if stats.TLS == nil {
+			stats.TLS = newTLSStats()
+		}
+		stats.TLS.merge(o.TLS)
+	}
+	if o.DNS != nil {
+		if stats.DNS == nil {
+			stats.DNS = &DNSStats{LookupHist: emptyLike(o.DNS.LookupHist), Addrs: make(map[string]int)}
+		}
+		stats.DNS.merge(o.DNS)
+	}
+	if o.Phases != nil {
+		if stats.Phases == nil {
+			stats.Phases = &PhaseStats{DNS: emptyLike(o.Phases.DNS), Connect: emptyLike(o.Phases.Connect),
+				TLS: emptyLike(o.Phases.TLS), Write: emptyLike(o.Phases.Write), TTFB: emptyLike(o.Phases.TTFB),
+				Transfer: emptyLike(o.Phases.Transfer)}
+		}
+		stats.Phases.merge(o.Phases)
+	}
+	if stats.Intervals == nil {
+		stats.Intervals = o.Intervals
+	}
+	if stats.Wire == nil {
+		stats.Wire = o.Wire
+	}
+	if stats.Conns == nil {
+		stats.Conns = o.Conns
+	}
+	if o.Assertions != nil {
+		if stats.Assertions == nil {
+			stats.Assertions = &AssertStats{Checks: make([]CheckStats, len(o.Assertions.Checks))}
+			for i, c := range o.Assertions.Checks {
+				stats.Assertions.Checks[i].Name = c.Name
+			}
+		}
+		stats.Assertions.merge(o.Assertions)
+	}
+	if o.Continue != nil {
+		if stats.Continue == nil {
+			stats.Continue = &ContinueStats{WaitHist: emptyLike(o.Continue.WaitHist)}
+		}
+		stats.Continue.merge(o.Continue)
+	}
 }
 
 func NewLoadCfg(duration int, // seconds
@@ -67,9 +329,44 @@ func NewLoadCfg(duration int, // seconds
 	clientCert string,
 	clientKey string,
 	caCert string,
-	http2 bool) (rt *LoadCfg) {
-	rt = &LoadCfg{duration, goroutines, testUrl, reqBody, method, host, header, statsAggregator, timeoutms,
-		allowRedirects, disableCompression, disableKeepAlive, skipVerify, 0, clientCert, clientKey, caCert, http2}
+	http2 bool,
+	opts ...Option) (rt *LoadCfg) {
+	rt = &LoadCfg{duration: duration, goroutines: goroutines, testUrl: testUrl, reqBody: reqBody, method: method,
+		host: host, header: header, statsAggregator: statsAggregator, timeoutms: timeoutms,
+		allowRedirects: allowRedirects, disableCompression: disableCompression, disableKeepAlive: disableKeepAlive,
+		skipVerify: skipVerify, clientCert: clientCert, clientKey: clientKey, caCert: caCert, http2: http2}
+	if IsUnixUrl(testUrl) {
+		// the connections are made to the socket, the requests are for the url set by WithUnixUrl
+		rt.unixSocket = strings.TrimPrefix(testUrl, "unix://")
+		rt.testUrl = DEFAULT_UNIX_URL
+	}
+	for _, opt := range opts {
+		opt(rt)
+	}
+	if rt.ws == nil && IsWebSocketUrl(testUrl) {
+		rt.ws, _ = NewWSCfg("", "", 0, "")
+	}
+	rt.pathLabel = rt.urlLabel()
+	rt.wire = &WireStats{}
+	rt.conns = newConnTracker(duration, !disableKeepAlive, rt.intervals)
+	if rt.expectContinue > 0 && len(reqBody) > 0 {
+		// a copy, the caller's headers are left as they are
+		rt.header = make(map[string]string, len(header)+1)
+		for k, v := range header {
+			rt.header[k] = v
+		}
+		rt.header["Expect"] = "100-continue"
+	}
+	if rt.raw == nil && rt.epollLoops > 0 {
+		rt.raw, _ = NewRawCfg(nil)
+	}
+	if rt.socket == nil && IsSocketUrl(testUrl) {
+		rt.socket, _ = NewSocketCfg("", 0, "")
+	}
+	if rt.grpc != nil && (rt.proto == "" || rt.proto == PROTO_AUTO) && strings.HasPrefix(rt.testUrl, "http://") {
+		// gRPC requires HTTP/2, which is never negotiated without TLS
+		rt.proto = PROTO_H2C
+	}
 	return
 }
 
@@ -103,8 +400,72 @@ func escapeUrlStr(in string) string {
 }
 
 // DoRequest single request implementation. Returns the size of the response and its duration
-// On error - returns -1 on both
+// On error - returns 0 on both, except for a status that is not 2xx or 3xx, a *StatusError with the size and duration
 func DoRequest(httpClient *http.Client, header map[string]string, method, host, loadUrl, reqBody string) (respSize int, duration time.Duration, err error) {
+	return doRequest(httpClient, header, method, host, loadUrl, reqBody, nil, nil, nil, nil)
+}
+
+// bodyValidator inspects a successfully received response and returns an error if it should count as a failure
+type bodyValidator func(resp *http.Response, body []byte) error
+
+// reqResult details of a single request, beyond its size and duration. The httptrace hooks may run on the transport
+// goroutines, even after the response was returned, so the fields they set are guarded by mu.
+type reqResult struct {
+	mu           sync.Mutex
+	proto        string    // the protocol of the response, empty when none was received
+	status       int       // the status code of the response, 0 when none was received
+	headSize     int64     // the estimated size of the request head
+	bodySize     int64     // the size of the request body
+	newConn      bool      // the request was sent on a new connection
+	connectStart time.Time // a new connection: when dialing started
+	connectDone  time.Time
+	tlsStart     time.Time // a new TLS connection: when the handshake started, after any proxy tunnel was set up
+	tlsDone      time.Time
+	gotConn      time.Time
+	dnsStart     time.Time
+	dnsDone      time.Time
+	remoteAddr   string   // the ip address of a new connection
+	conn         net.Conn // the connection the request was sent on
+	wroteHeaders time.Time
+	wroteRequest time.Time
+	got100       time.Time            // when a 100 Continue was received
+	firstByte    time.Time            // of the first response, interim or final
+	body         *sentBody            // the request body, nil when there was none
+	done         time.Time            // the end of the response body
+	tls          *tls.ConnectionState // of the connection the response was received on
+}
+
+// bodyUnsent the request had a body, and none of it was sent
+func (res *reqResult) bodyUnsent() bool {
+	return res.body != nil && !res.body.read.Load()
+}
+
+// sentBody a request body that records whether the transport started reading it. The transport may still read it
+// on its own goroutine after the response was returned.
+type sentBody struct {
+	io.ReadCloser
+	read atomic.Bool
+}
+
+func (b *sentBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	return b.ReadCloser.Read(p)
+}
+
+// sentSize the estimated size of the request that was sent, 0 when its head never was. The caller holds mu.
+func (res *reqResult) sentSize() int64 {
+	if res.wroteHeaders.IsZero() {
+		return 0
+	}
+	if res.bodyUnsent() {
+		return res.headSize
+	}
+	return res.headSize + res.bodySize
+}
+
+// doRequest the DoRequest implementation. body, success, validate and res may be nil, a validated body is always read
+// in full. A response with a status that is not successful returns its size and duration with a *StatusError.
+func doRequest(httpClient *http.Client, header map[string]string, method, host, loadUrl, reqBody string, body *BodyCfg, success *SuccessCfg, validate bodyValidator, res *reqResult) (respSize int, duration time.Duration, err error) {
 	respSize = -1
 	duration = -1
 
@@ -117,7 +478,7 @@ func DoRequest(httpClient *http.Client, header map[string]string, method, host,
 
 	req, err := http.NewRequest(method, loadUrl, buf)
 	if err != nil {
-		return 0,0,err
+		return 0, 0, err
 	}
 
 	for hk, hv := range header {
@@ -128,45 +489,139 @@ func DoRequest(httpClient *http.Client, header map[string]string, method, host,
 	if host != "" {
 		req.Host = host
 	}
+	if res != nil {
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			DNSStart: func(httptrace.DNSStartInfo) {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				res.dnsStart = time.Now()
+			},
+			DNSDone: func(httptrace.DNSDoneInfo) {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				res.dnsDone = time.Now()
+			},
+			ConnectStart: func(network, addr string) {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				if res.connectStart.IsZero() {
+					res.connectStart = time.Now()
+				}
+			},
+			ConnectDone: func(network, addr string, err error) {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				if err == nil && res.connectDone.IsZero() {
+					res.connectDone = time.Now()
+				}
+			},
+			WroteHeaders: func() {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				res.wroteHeaders = time.Now()
+			},
+			WroteRequest: func(httptrace.WroteRequestInfo) {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				res.wroteRequest = time.Now()
+			},
+			Got100Continue: func() {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				res.got100 = time.Now()
+			},
+			GotFirstResponseByte: func() {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				res.firstByte = time.Now()
+			},
+			TLSHandshakeStart: func() {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				if res.tlsStart.IsZero() {
+					res.tlsStart = time.Now()
+				}
+			},
+			TLSHandshakeDone: func(tls.ConnectionState, error) {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				res.tlsDone = time.Now()
+			},
+			GotConn: func(info httptrace.GotConnInfo) {
+				res.mu.Lock()
+				defer res.mu.Unlock()
+				res.newConn = !info.Reused
+				res.gotConn = time.Now()
+				res.conn = info.Conn
+				trackRequest(info.Conn, info.IdleTime)
+				if !info.Reused {
+					if host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+						res.remoteAddr = host
+					}
+				}
+			},
+		}))
+	}
+	if res != nil {
+		res.headSize = util.EstimateHttpRequestHeadSize(req)
+		res.bodySize = req.ContentLength
+		if req.Body != nil {
+			res.body = &sentBody{ReadCloser: req.Body}
+			req.Body = res.body
+		}
+	}
 	start := time.Now()
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		// this is a bit weird. When redirection is prevented, a url.Error is retuned. This creates an issue to distinguish
-		// between an invalid URL that was provided and and redirection error.
-		_, ok := err.(*url.Error)
-		if !ok {
-			return 0,0,err
-		}
-		return 0,0,err
+		// a prevented redirection is a *util.RedirectError inside the *url.Error, see classifyError
+		return 0, 0, err
 	}
 	if resp == nil {
-		return 0,0,errors.New("empty response")
+		return 0, 0, errors.New("empty response")
 	}
 	defer func() {
 		if resp != nil && resp.Body != nil {
 			resp.Body.Close()
 		}
 	}()
-	body, err := io.ReadAll(resp.Body)
+	if res != nil {
+		res.proto = resp.Proto
+		res.status = resp.StatusCode
+		res.tls = resp.TLS
+		res.mu.Lock()
+		conn := res.conn
+		res.mu.Unlock()
+		if resp.Close {
+			trackServerClose(conn)
+		}
+	}
+	ok := success.ok(resp.StatusCode)
+	if validate != nil && ok {
+		body = &BodyCfg{mode: BODY_FULL}
+	}
+	bodySize, data, err := body.read(resp.Body)
 	if err != nil {
-		return 0,0,err
-	}
-	if resp.StatusCode/100 == 2 { // Treat all 2XX as successful
-		duration = time.Since(start)
-		respSize = len(body) + int(util.EstimateHttpHeadersSize(resp.Header))
-	} else if resp.StatusCode == http.StatusMovedPermanently || resp.StatusCode == http.StatusTemporaryRedirect {
-		duration = time.Since(start)
-		respSize = int(resp.ContentLength) + int(util.EstimateHttpHeadersSize(resp.Header))
-	} else {
-		return 0,0,errors.New(fmt.Sprint("received status code ", resp.StatusCode))
+		return 0, 0, &bodyError{err}
+	}
+	if res != nil {
+		res.done = time.Now()
+	}
+	duration = time.Since(start)
+	respSize = int(bodySize) + int(util.EstimateHttpResponseHeadSize(resp))
+	if !ok {
+		return respSize, duration, &StatusError{Code: resp.StatusCode}
+	}
+	if validate != nil {
+		if err = validate(resp, data); err != nil {
+			return respSize, duration, err
+		}
 	}
-
 	return
 }
 
 func unwrap(err error) error {
-	for errors.Unwrap(err)!=nil {
-		err = errors.Unwrap(err);
+	for errors.Unwrap(err) != nil {
+		err = errors.Unwrap(err)
 	}
 	return err
 }
@@ -174,32 +629,199 @@ func unwrap(err error) error {
 // Requester a go function for repeatedly making requests and aggregating statistics as long as required
 // When it is done, it sends the results using the statsAggregator channel
 func (cfg *LoadCfg) RunSingleLoadSession() {
-	stats := &RequesterStats{ErrMap: make(map[string]int), Histogram: histo.New(1,int64(cfg.duration * 1000000),4)}
+	stats := NewRequesterStats(cfg.duration)
+	stats.Wire = cfg.wire
+	stats.Conns = cfg.conns
 	start := time.Now()
+	if cfg.intervals != nil {
+		stats.Intervals = cfg.intervals
+		stats.interval = cfg.intervals.recorder()
+	}
+
+	if IsWebSocketUrl(cfg.testUrl) {
+		cfg.runWebSocketSession(stats, start)
+		cfg.report(stats)
+		return
+	}
+	if IsSocketUrl(cfg.testUrl) {
+		cfg.runSocketSession(stats, start)
+		cfg.report(stats)
+		return
+	}
+
+	if cfg.pipeline > 0 {
+		cfg.runPipelineSession(stats, start)
+		cfg.report(stats)
+		return
+	}
+	if cfg.epollLoops > 0 {
+		cfg.runEpollSession(stats, start)
+		cfg.report(stats)
+		return
+	}
+	if cfg.raw != nil {
+		cfg.runRawSession(stats, start)
+		cfg.report(stats)
+		return
+	}
 
-	httpClient, err := client(cfg.disableCompression, cfg.disableKeepAlive, cfg.skipVerify,
-		cfg.timeoutms, cfg.allowRedirects, cfg.clientCert, cfg.clientKey, cfg.caCert, cfg.http2)
+	httpClient, err := cfg.sessionClient()
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for time.Since(start).Seconds() <= float64(cfg.duration) && atomic.LoadInt32(&cfg.interrupted) == 0 {
-		respSize, reqDur, err := DoRequest(httpClient, cfg.header, cfg.method, cfg.host, cfg.testUrl, cfg.reqBody)
+	if cfg.stream != nil {
+		cfg.runStreamSession(httpClient, stats, start)
+		cfg.report(stats)
+		return
+	}
+
+	for !cfg.done(start) {
+		stats.tick()
+		var respSize int
+		var reqDur time.Duration
+		var err error
+		var res reqResult
+		var op string
+		if cfg.grpc != nil {
+			if stats.GRPC == nil {
+				stats.GRPC = newGRPCStats()
+			}
+			respSize, reqDur, err = cfg.grpc.doRequest(httpClient, cfg.host, cfg.testUrl, cfg.success, stats.GRPC, &res)
+		} else if cfg.graphql != nil {
+			op, respSize, reqDur, err = cfg.graphql.doRequest(httpClient, cfg.host, cfg.testUrl, cfg.success, &res)
+			group(&stats.Operations, op, cfg.duration).record(reqDur, err)
+		} else {
+			var validate bodyValidator
+			if cfg.assert != nil {
+				validate = cfg.assert.validator(stats)
+			}
+			respSize, reqDur, err = doRequest(httpClient, cfg.header, cfg.method, cfg.host, cfg.testUrl, cfg.reqBody, cfg.body, cfg.success, validate, &res)
+		}
+		group(&stats.Labels, cfg.requestLabel(op), cfg.duration).record(reqDur, err)
+		if res.proto != "" {
+			stats.Protocols[res.proto]++
+		}
+		if res.status != 0 {
+			stats.recordStatus(res.status, !failedResponse(err), reqDur)
+		}
+		// the response body was read and closed, the hooks that still run wait for the statistics to be taken
+		res.mu.Lock()
+		stats.TotReqSize += res.sentSize()
+		if res.newConn {
+			stats.ConnsOpened++
+			if res.proto == "HTTP/2.0" {
+				stats.H2Conns++
+			}
+			cfg.recordProxyConnect(stats, &res)
+			cfg.recordDNS(stats, &res)
+			if res.tls != nil {
+				stats.recordTLS(res.tls)
+			}
+		}
+		cfg.recordContinue(stats, &res)
+		cfg.recordPhases(stats, &res)
+		res.mu.Unlock()
 		if err != nil {
-			stats.ErrMap[unwrap(err).Error()]+=1
-			stats.NumErrs++
-		} else if respSize > 0 {
+			if h2Err := classifyHTTP2Error(err); h2Err != "" {
+				stats.H2Errors[h2Err]++
+				stats.NumErrs++
+			} else {
+				stats.recordError(err)
+			}
+			// the failed responses were received in full and took their share of the time, see failedResponse
 			stats.TotRespSize += int64(respSize)
 			stats.TotDuration += reqDur
-			stats.Histogram.RecordValue(reqDur.Microseconds());
-			stats.NumRequests++
 		} else {
-			stats.NumErrs++
+			// an empty response, e.g. a 204, is as successful as any other
+			stats.TotRespSize += int64(respSize)
+			stats.TotDuration += reqDur
+			stats.recordLatency(reqDur)
+			stats.NumRequests++
 		}
 	}
-	cfg.statsAggregator <- stats
+	cfg.report(stats)
+}
+
+// clientOptions the client settings derived from the optional features
+func (cfg *LoadCfg) clientOptions() (opts []clientOption) {
+	if cfg.proto != "" {
+		opts = append(opts, withProto(cfg.proto))
+	}
+	if cfg.h2 != nil {
+		opts = append(opts, withHTTP2(cfg.h2))
+	}
+	if cfg.stream != nil {
+		opts = append(opts, withStreaming())
+	}
+	if cfg.unixSocket != "" {
+		opts = append(opts, withUnixSocket(cfg.unixSocket))
+	}
+	if cfg.proxy != nil {
+		opts = append(opts, withProxy(cfg.proxy))
+	}
+	if cfg.tls != nil {
+		opts = append(opts, withTLS(cfg.tls))
+	}
+	if cfg.resolve != nil {
+		opts = append(opts, withResolve(cfg.resolve))
+	}
+	if cfg.bind != nil {
+		opts = append(opts, withBind(cfg.bind))
+	}
+	if cfg.expectContinue > 0 {
+		opts = append(opts, withExpectContinue(cfg.expectContinue))
+	}
+	if cfg.wire != nil {
+		opts = append(opts, withWire(cfg.wire))
+	}
+	if cfg.conns != nil {
+		opts = append(opts, withConns(cfg.conns))
+	}
+	return
+}
+
+func (cfg *LoadCfg) newClient() (*http.Client, error) {
+	return client(cfg.disableCompression, cfg.disableKeepAlive, cfg.skipVerify,
+		cfg.timeoutms, cfg.allowRedirects, cfg.clientCert, cfg.clientKey, cfg.caCert, cfg.http2, cfg.clientOptions()...)
+}
+
+// sessionClient the client of a single load session. When HTTP/2 connections are shared, the sessions are spread
+// evenly between the shared clients, so each connection carries goroutines/Conns concurrent streams
+func (cfg *LoadCfg) sessionClient() (*http.Client, error) {
+	if cfg.h2 == nil || cfg.h2.Conns <= 0 {
+		return cfg.newClient()
+	}
+	cfg.sharedClientsOnce.Do(func() {
+		for i := 0; i < cfg.h2.Conns; i++ {
+			c, err := cfg.newClient()
+			if err != nil {
+				cfg.sharedClientsErr = err
+				return
+			}
+			cfg.sharedClients = append(cfg.sharedClients, c)
+		}
+	})
+	if cfg.sharedClientsErr != nil {
+		return nil, cfg.sharedClientsErr
+	}
+	i := atomic.AddUint32(&cfg.nextSharedClient, 1) - 1
+	return cfg.sharedClients[int(i)%len(cfg.sharedClients)], nil
+}
+
+// done true when a session that started at start should stop
+func (cfg *LoadCfg) done(start time.Time) bool {
+	return time.Since(start).Seconds() > float64(cfg.duration) || atomic.LoadInt32(&cfg.interrupted) != 0
 }
 
 func (cfg *LoadCfg) Stop() {
 	atomic.StoreInt32(&cfg.interrupted, 1)
 }
+
+// Close releases what the sessions share once all of them returned, the idle connections of the raw engine. A
+// session that ends first must not close the connections the others are about to reuse.
+func (cfg *LoadCfg) Close() {
+	if cfg.raw != nil {
+		cfg.raw.closeIdle()
+	}
+}
@@ -1,9 +1,11 @@
 package loader
 
 import (
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -14,6 +16,12 @@ const sessionDeadline = 5 * time.Second
 func runSession(t *testing.T, cfg *LoadCfg, ch <-chan *RequesterStats) *RequesterStats {
 	t.Helper()
 	go cfg.RunSingleLoadSession()
+	return runSessionResult(t, ch)
+}
+
+// runSessionResult waits for the stats of a session that is already running
+func runSessionResult(t *testing.T, ch <-chan *RequesterStats) *RequesterStats {
+	t.Helper()
 	select {
 	case s := <-ch:
 		return s
@@ -39,7 +47,7 @@ func TestRunSingleLoadSession_HappyPath(t *testing.T) {
 		t.Fatal("NumRequests = 0, want > 0")
 	}
 	if stats.NumErrs != 0 {
-		t.Errorf("NumErrs = %d, want 0; ErrMap=%v", stats.NumErrs, stats.ErrMap)
+		t.Errorf("NumErrs = %d, want 0; Errors=%v", stats.NumErrs, stats.Errors)
 	}
 	if stats.TotRespSize <= 0 {
 		t.Errorf("TotRespSize = %d, want > 0", stats.TotRespSize)
@@ -53,8 +61,8 @@ func TestRunSingleLoadSession_HappyPath(t *testing.T) {
 	if got := stats.Histogram.TotalCount(); got != int64(stats.NumRequests) {
 		t.Errorf("Histogram.TotalCount() = %d, NumRequests = %d", got, stats.NumRequests)
 	}
-	if len(stats.ErrMap) != 0 {
-		t.Errorf("ErrMap not empty: %v", stats.ErrMap)
+	if len(stats.Errors) != 0 {
+		t.Errorf("Errors not empty: %v", stats.Errors)
 	}
 }
 
@@ -76,16 +84,15 @@ func TestRunSingleLoadSession_ServerErrorsAccumulate(t *testing.T) {
 		t.Fatal("NumErrs = 0, want > 0")
 	}
 
-	var key string
-	for k := range stats.ErrMap {
-		key = k
-		break
+	e := stats.Errors[ERR_STATUS]
+	if len(stats.Errors) != 1 || e == nil {
+		t.Fatalf("Errors = %v, want only %q", stats.Errors, ERR_STATUS)
 	}
-	if !strings.Contains(key, "status code 500") {
-		t.Errorf("ErrMap key = %q, want substring %q", key, "status code 500")
+	if !strings.Contains(e.Examples[0], "status code 500") {
+		t.Errorf("Examples = %q, want substring %q", e.Examples, "status code 500")
 	}
-	if stats.ErrMap[key] != stats.NumErrs {
-		t.Errorf("ErrMap[%q] = %d, NumErrs = %d", key, stats.ErrMap[key], stats.NumErrs)
+	if e.Count != stats.NumErrs {
+		t.Errorf("Errors[%q].Count = %d, NumErrs = %d", ERR_STATUS, e.Count, stats.NumErrs)
 	}
 }
 
@@ -136,8 +143,8 @@ func TestRunSingleLoadSession_BadURL(t *testing.T) {
 	if stats.NumErrs == 0 {
 		t.Fatal("NumErrs = 0, want > 0")
 	}
-	if len(stats.ErrMap) == 0 {
-		t.Fatal("ErrMap empty, want at least one entry")
+	if len(stats.Errors) == 0 {
+		t.Fatal("Errors empty, want at least one entry")
 	}
 }
 
@@ -169,3 +176,52 @@ func TestRunSingleLoadSession_BodyAndMethod(t *testing.T) {
 		t.Error("server never observed a POST request")
 	}
 }
+
+func TestRunSingleLoadSession_CountsProtocols(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(ts.Close)
+
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", true, WithProto(PROTO_H1))
+
+	stats := runSession(t, cfg, ch)
+
+	if got := stats.Protocols["HTTP/1.1"]; got != stats.NumRequests || got == 0 {
+		t.Errorf("Protocols = %v, want HTTP/1.1=%d", stats.Protocols, stats.NumRequests)
+	}
+}
+
+func TestRunSingleLoadSession_UnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "test.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(chan string, 1)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		select {
+		case got <- r.Method + " " + r.Host + r.URL.Path + " " + r.Header.Get("X-Test") + " " + string(body):
+		default:
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	ts.Listener = l
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, "unix://"+sock, "hello", "POST", "", map[string]string{"X-Test": "1"}, ch, 1000, true, false, false, false,
+		"", "", "", true, WithUnixUrl("http://sidecar/ping"))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumRequests == 0 || stats.NumErrs != 0 {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+	if req, want := <-got, "POST sidecar/ping 1 hello"; req != want {
+		t.Errorf("request = %q, want %q", req, want)
+	}
+}
@@ -0,0 +1,72 @@
+package loader
+
+import (
+	histo "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// PhaseStats the time spent in each phase of the requests. DNS, Connect and TLS are only recorded for the requests
+// that opened a new connection.
+type PhaseStats struct {
+	DNS      *histo.Histogram
+	Connect  *histo.Histogram // TCP (or unix socket) connect
+	TLS      *histo.Histogram // the TLS handshake
+	Write    *histo.Histogram // from getting a connection to writing the whole request
+	TTFB     *histo.Histogram // from writing the request to the first byte of the response, the server think time
+	Transfer *histo.Histogram // from the first byte of the response to the end of its body
+}
+
+// Phase a named phase histogram, in the order of the phases
+type Phase struct {
+	Name      string
+	Histogram *histo.Histogram
+}
+
+func newPhaseStats(duration int) *PhaseStats {
+	return &PhaseStats{DNS: newHistogram(duration), Connect: newHistogram(duration), TLS: newHistogram(duration),
+		Write: newHistogram(duration), TTFB: newHistogram(duration), Transfer: newHistogram(duration)}
+}
+
+// List the phases in the order they happen
+func (p *PhaseStats) List() []Phase {
+	return []Phase{{"DNS", p.DNS}, {"Connect", p.Connect}, {"TLS", p.TLS}, {"Write", p.Write}, {"TTFB", p.TTFB},
+		{"Transfer", p.Transfer}}
+}
+
+func (p *PhaseStats) merge(o *PhaseStats) {
+	p.DNS.Merge(o.DNS)
+	p.Connect.Merge(o.Connect)
+	p.TLS.Merge(o.TLS)
+	p.Write.Merge(o.Write)
+	p.TTFB.Merge(o.TTFB)
+	p.Transfer.Merge(o.Transfer)
+}
+
+// recordPhases records the phases of a request that received a response
+func (cfg *LoadCfg) recordPhases(stats *RequesterStats, res *reqResult) {
+	if res.firstByte.IsZero() {
+		return
+	}
+	if stats.Phases == nil {
+		stats.Phases = newPhaseStats(cfg.duration)
+	}
+	p := stats.Phases
+	if !res.dnsStart.IsZero() && !res.dnsDone.IsZero() {
+		p.DNS.RecordValue(res.dnsDone.Sub(res.dnsStart).Microseconds())
+	}
+	if !res.connectStart.IsZero() && !res.connectDone.IsZero() {
+		p.Connect.RecordValue(res.connectDone.Sub(res.connectStart).Microseconds())
+	}
+	if !res.tlsStart.IsZero() && !res.tlsDone.IsZero() {
+		p.TLS.RecordValue(res.tlsDone.Sub(res.tlsStart).Microseconds())
+	}
+	if !res.gotConn.IsZero() && !res.wroteRequest.IsZero() {
+		p.Write.RecordValue(res.wroteRequest.Sub(res.gotConn).Microseconds())
+	}
+	// with Expect: 100-continue the first byte, of the 100 Continue, comes before the body is written
+	if !res.wroteRequest.IsZero() && !res.firstByte.Before(res.wroteRequest) {
+		p.TTFB.RecordValue(res.firstByte.Sub(res.wroteRequest).Microseconds())
+	}
+	if !res.done.IsZero() {
+		p.Transfer.RecordValue(res.done.Sub(res.firstByte).Microseconds())
+	}
+}
@@ -0,0 +1,65 @@
+package loader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRunSingleLoadSession_Phases(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte("ok"))
+	})
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	tlsServer := httptest.NewTLSServer(handler)
+	defer tlsServer.Close()
+
+	for _, tc := range []struct {
+		url         string
+		noKeepAlive bool
+		tls         bool
+	}{
+		{ts.URL, false, false},
+		{ts.URL, true, false},
+		{tlsServer.URL, true, true},
+	} {
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, tc.url, "", "GET", "", nil, ch, 1000, true, false, tc.noKeepAlive, true,
+			"", "", "", false)
+		stats := runSession(t, cfg, ch)
+		p := stats.Phases
+		if stats.NumRequests == 0 || stats.NumErrs != 0 || p == nil {
+			t.Fatalf("%v: NumRequests = %d, NumErrs = %d, Errors = %v, Phases = %v", tc.url, stats.NumRequests,
+				stats.NumErrs, stats.Errors, p)
+		}
+		requests := int64(stats.NumRequests)
+		for _, phase := range []Phase{{"Write", p.Write}, {"TTFB", p.TTFB}, {"Transfer", p.Transfer}} {
+			if phase.Histogram.TotalCount() != requests {
+				t.Errorf("%v: %v count = %d, want %d", tc.url, phase.Name, phase.Histogram.TotalCount(), requests)
+			}
+		}
+		if p.Connect.TotalCount() != int64(stats.ConnsOpened) {
+			t.Errorf("%v: Connect count = %d, want %d", tc.url, p.Connect.TotalCount(), stats.ConnsOpened)
+		}
+		if tc.noKeepAlive && stats.ConnsOpened != stats.NumRequests {
+			t.Errorf("%v: ConnsOpened = %d, want a connection per request", tc.url, stats.ConnsOpened)
+		}
+		wantTLS := int64(0)
+		if tc.tls {
+			wantTLS = int64(stats.ConnsOpened)
+		}
+		if p.TLS.TotalCount() != wantTLS {
+			t.Errorf("%v: TLS count = %d, want %d", tc.url, p.TLS.TotalCount(), wantTLS)
+		}
+		// the server think time is in the TTFB, not in the other phases
+		if min := p.TTFB.Min(); min < (20 * time.Millisecond).Microseconds() {
+			t.Errorf("%v: TTFB min = %dµs, want at least the 20ms of the handler", tc.url, min)
+		}
+		if max := p.Transfer.Max(); max >= (20 * time.Millisecond).Microseconds() {
+			t.Errorf("%v: Transfer max = %dµs, want less than the handler sleep", tc.url, max)
+		}
+	}
+}
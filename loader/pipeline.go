@@ -0,0 +1,198 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/tsliwowicz/go-wrk/util"
+)
+
+// PipelineStats HTTP/1.1 pipelining statistics. The pipelined requests are the requests of the common statistics.
+type PipelineStats struct {
+	Depth       int // requests written on a connection before reading the responses
+	Batches     int
+	Connects    int
+	ConnectErrs int
+	Unanswered  int // requests without a response because the connection failed or was closed in the middle of a batch
+}
+
+// WithPipeline writes depth requests at a time on a single HTTP/1.1 connection, then reads the responses in order.
+// A depth below 2 makes plain requests.
+func WithPipeline(depth int) Option {
+	return func(cfg *LoadCfg) {
+		if depth > 1 {
+			cfg.pipeline = depth
+		}
+	}
+}
+
+func (p *PipelineStats) merge(o *PipelineStats) {
+	if o.Depth > p.Depth {
+		p.Depth = o.Depth
+	}
+	p.Batches += o.Batches
+	p.Connects += o.Connects
+	p.ConnectErrs += o.ConnectErrs
+	p.Unanswered += o.Unanswered
+}
+
+// pipelineRequest the request, and the same request serialized for the wire
+func (cfg *LoadCfg) pipelineRequest() (*http.Request, []byte, error) {
+	var body io.Reader
+	if len(cfg.reqBody) > 0 {
+		body = strings.NewReader(cfg.reqBody)
+	}
+	req, err := http.NewRequest(cfg.method, escapeUrlStr(cfg.testUrl), body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, nil, fmt.Errorf("pipelining requires an http or https url, got %q", req.URL.Scheme)
+	}
+	for hk, hv := range cfg.header {
+		req.Header.Add(hk, hv)
+	}
+	req.Header.Set("User-Agent", USER_AGENT)
+	if cfg.host != "" {
+		req.Host = cfg.host
+	}
+	var buf bytes.Buffer
+	if err = req.Write(&buf); err != nil {
+		return nil, nil, err
+	}
+	return req, buf.Bytes(), nil
+}
+
+// pipelineDial a connection for pipelining, TLS connections only offer HTTP/1.1
+func (cfg *LoadCfg) pipelineDial(req *http.Request) (net.Conn, error) {
+	timeout := time.Millisecond * time.Duration(cfg.timeoutms)
+	network, addr := "tcp", req.URL.Host
+	if req.URL.Port() == "" {
+		addr = net.JoinHostPort(req.URL.Hostname(), map[string]string{"http": "80", "https": "443"}[req.URL.Scheme])
+	}
+	if cfg.unixSocket != "" {
+		network, addr = "unix", cfg.unixSocket
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, err := cfg.sessionDial()(ctx, network, addr)
+	if err != nil || req.URL.Scheme != "https" {
+		return conn, err
+	}
+	tlsConfig, err := clientTLSConfig(cfg.skipVerify, cfg.clientCert, cfg.clientKey, cfg.caCert)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if cfg.tls != nil {
+		cfg.tls.apply(tlsConfig)
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = req.URL.Hostname()
+	}
+	tlsConfig.NextProtos = []string{"http/1.1"}
+	tc := tls.Client(conn, tlsConfig)
+	if err = tc.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// runPipelineSession the RunSingleLoadSession loop for pipelined requests. The latency of a request is the time from
+// writing its batch to the end of its response, so it includes waiting for the responses before it.
+func (cfg *LoadCfg) runPipelineSession(stats *RequesterStats, start time.Time) {
+	stats.Pipeline = &PipelineStats{Depth: cfg.pipeline}
+	req, request, err := cfg.pipelineRequest()
+	if err != nil {
+		stats.recordError(err)
+		return
+	}
+	batch := bytes.Repeat(request, cfg.pipeline)
+	timeout := time.Millisecond * time.Duration(cfg.timeoutms)
+
+	var conn net.Conn
+	var rd *bufio.Reader
+	closeConn := func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	defer closeConn()
+	fail := func(err error) {
+		stats.recordError(err)
+	}
+
+	for !cfg.done(start) {
+		stats.tick()
+		if conn == nil {
+			if conn, err = cfg.pipelineDial(req); err != nil {
+				fail(err)
+				stats.Pipeline.ConnectErrs++
+				continue
+			}
+			stats.Pipeline.Connects++
+			if tc, ok := conn.(*tls.Conn); ok {
+				state := tc.ConnectionState()
+				stats.recordTLS(&state)
+			}
+			rd = bufio.NewReader(conn)
+		}
+
+		sent := time.Now()
+		conn.SetDeadline(sent.Add(timeout))
+		if _, err = conn.Write(batch); err != nil {
+			fail(err)
+			stats.Pipeline.Unanswered += cfg.pipeline
+			closeConn()
+			continue
+		}
+		stats.Pipeline.Batches++
+		stats.TotReqSize += int64(len(batch))
+		for i := 0; i < cfg.pipeline; i++ {
+			closing, err := cfg.readPipelined(rd, req, sent, stats)
+			if err != nil {
+				fail(err)
+			}
+			if err != nil || closing {
+				stats.Pipeline.Unanswered += cfg.pipeline - i - 1
+				closeConn()
+				break
+			}
+		}
+		stats.TotDuration += time.Since(sent)
+	}
+}
+
+// readPipelined reads the next response of a batch. closing is true when the server closes the connection after it.
+func (cfg *LoadCfg) readPipelined(rd *bufio.Reader, req *http.Request, sent time.Time, stats *RequesterStats) (closing bool, err error) {
+	resp, err := http.ReadResponse(rd, req)
+	if err != nil {
+		return true, &headerError{err}
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return true, &bodyError{err}
+	}
+	reqDur := time.Since(sent)
+	ok := cfg.success.ok(resp.StatusCode)
+	stats.recordStatus(resp.StatusCode, ok, reqDur)
+	if !ok {
+		stats.recordError(&StatusError{Code: resp.StatusCode})
+		return resp.Close, nil
+	}
+	stats.TotRespSize += n + util.EstimateHttpResponseHeadSize(resp)
+	stats.recordLatency(reqDur)
+	stats.NumRequests++
+	return resp.Close, nil
+}
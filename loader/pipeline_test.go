@@ -0,0 +1,95 @@
+package loader
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newPipelineTestServer answers only once a whole batch of depth requests arrived, so it fails anything that waits
+// for a response before sending the next request
+func newPipelineTestServer(t *testing.T, depth int) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				rd := bufio.NewReader(c)
+				for {
+					for i := 0; i < depth; i++ {
+						req, err := http.ReadRequest(rd)
+						if err != nil {
+							return
+						}
+						req.Body.Close()
+					}
+					resp := "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok"
+					if _, err := c.Write([]byte(strings.Repeat(resp, depth))); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "http://" + l.Addr().String() + "/"
+}
+
+func TestRunSingleLoadSession_Pipeline(t *testing.T) {
+	u := newPipelineTestServer(t, 4)
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, u, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false, WithPipeline(4))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.Pipeline == nil {
+		t.Fatal("Pipeline stats = nil")
+	}
+	if stats.NumRequests == 0 || stats.NumErrs != 0 {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+	p := stats.Pipeline
+	if p.Depth != 4 || p.Connects != 1 || stats.NumRequests != 4*p.Batches {
+		t.Errorf("Depth = %d, Connects = %d, Batches = %d, NumRequests = %d", p.Depth, p.Connects, p.Batches, stats.NumRequests)
+	}
+}
+
+func TestRunSingleLoadSession_PipelineServerCloses(t *testing.T) {
+	// the server answers the first request of every batch and closes the connection
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Connection", "close")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(ts.Close)
+
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false, WithPipeline(3))
+
+	stats := runSession(t, cfg, ch)
+
+	p := stats.Pipeline
+	if stats.NumRequests == 0 || stats.NumRequests != p.Batches || p.Connects != p.Batches {
+		t.Errorf("NumRequests = %d, Batches = %d, Connects = %d, want all equal", stats.NumRequests, p.Batches, p.Connects)
+	}
+	if p.Unanswered != 2*p.Batches {
+		t.Errorf("Unanswered = %d, want %d", p.Unanswered, 2*p.Batches)
+	}
+}
+
+func TestWithPipeline_Off(t *testing.T) {
+	cfg := NewLoadCfg(1, 1, "http://x/", "", "GET", "", nil, nil, 1000, true, false, false, false, "", "", "", false, WithPipeline(1))
+	if cfg.pipeline != 0 {
+		t.Errorf("pipeline = %d, want 0 for a depth of 1", cfg.pipeline)
+	}
+}
@@ -0,0 +1,74 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// ProtoDescriptors the message, enum and service definitions of a FileDescriptorSet
+// (as produced by protoc --include_imports --descriptor_set_out)
+type ProtoDescriptors struct {
+	files *protoregistry.Files
+	types *dynamicpb.Types // resolves the google.protobuf.Any types
+}
+
+// ParseProtoDescriptors reads a serialized google.protobuf.FileDescriptorSet
+func ParseProtoDescriptors(set []byte) (*ProtoDescriptors, error) {
+	var fds descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(set, &fds); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %v", err)
+	}
+	files, err := protodesc.NewFiles(&fds)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set (did protoc run with --include_imports?): %v", err)
+	}
+	messages := 0
+	files.RangeFiles(func(f protoreflect.FileDescriptor) bool {
+		messages += f.Messages().Len()
+		return true
+	})
+	if messages == 0 {
+		return nil, errors.New("descriptor set has no message definitions")
+	}
+	return &ProtoDescriptors{files: files, types: dynamicpb.NewTypes(files)}, nil
+}
+
+// InputType the request message type of a package.Service/Method
+func (d *ProtoDescriptors) InputType(method string) (string, error) {
+	service, name, _ := strings.Cut(method, "/")
+	if desc, err := d.files.FindDescriptorByName(protoreflect.FullName(service)); err == nil {
+		if s, ok := desc.(protoreflect.ServiceDescriptor); ok {
+			if m := s.Methods().ByName(protoreflect.Name(name)); m != nil {
+				return string(m.Input().FullName()), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("method %q not found in the descriptor set", method)
+}
+
+// EncodeJSON converts a JSON object (in the protobuf JSON mapping) to the serialized protobuf message of the given type
+func (d *ProtoDescriptors) EncodeJSON(msgType string, data []byte) ([]byte, error) {
+	desc, err := d.files.FindDescriptorByName(protoreflect.FullName(msgType))
+	if err != nil {
+		return nil, fmt.Errorf("message type %q not found in the descriptor set", msgType)
+	}
+	md, ok := desc.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%q is not a message type", msgType)
+	}
+	msg := dynamicpb.NewMessage(md)
+	if err = (protojson.UnmarshalOptions{Resolver: d.types}).Unmarshal(data, msg); err != nil {
+		return nil, fmt.Errorf("invalid JSON message for %v: %v", msgType, err)
+	}
+	// deterministic, so the same JSON always makes the same message
+	return proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+}
@@ -0,0 +1,189 @@
+package loader
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func testField(name string, number int32, label descriptorpb.FieldDescriptorProto_Label,
+	typ descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	f := &descriptorpb.FieldDescriptorProto{Name: proto.String(name), Number: proto.Int32(number), Label: label.Enum(),
+		Type: typ.Enum(), JsonName: proto.String(name)}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+// testDescriptorSet describes
+//
+//	package test;
+//	import "google/protobuf/timestamp.proto"; // and duration, wrappers, struct
+//	enum Kind { NONE = 0; FAST = 1; }
+//	message Req {
+//	  string name = 1; int32 count = 2; repeated int64 ids = 3; Kind kind = 4; Inner inner = 5; double ratio = 6;
+//	  google.protobuf.Timestamp at = 7; google.protobuf.Duration timeout = 8; google.protobuf.Int32Value limit = 9;
+//	  google.protobuf.Struct extra = 10;
+//	}
+//	message Inner { bool on = 1; }
+//	service Svc { rpc Call(Req) returns (Req); }
+func testDescriptorSet(withImports bool) []byte {
+	const (
+		optional = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		repeated = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		message  = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE
+	)
+	file := &descriptorpb.FileDescriptorProto{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		Dependency: []string{"google/protobuf/timestamp.proto", "google/protobuf/duration.proto",
+			"google/protobuf/wrappers.proto", "google/protobuf/struct.proto"},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Kind"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("NONE"), Number: proto.Int32(0)},
+				{Name: proto.String("FAST"), Number: proto.Int32(1)},
+			},
+		}},
+		MessageType: []*descriptorpb.DescriptorProto{{
+			Name: proto.String("Req"),
+			Field: []*descriptorpb.FieldDescriptorProto{
+				testField("name", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				testField("count", 2, optional, descriptorpb.FieldDescriptorProto_TYPE_INT32, ""),
+				testField("ids", 3, repeated, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+				testField("kind", 4, optional, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.Kind"),
+				testField("inner", 5, optional, message, ".test.Inner"),
+				testField("ratio", 6, optional, descriptorpb.FieldDescriptorProto_TYPE_DOUBLE, ""),
+				testField("at", 7, optional, message, ".google.protobuf.Timestamp"),
+				testField("timeout", 8, optional, message, ".google.protobuf.Duration"),
+				testField("limit", 9, optional, message, ".google.protobuf.Int32Value"),
+				testField("extra", 10, optional, message, ".google.protobuf.Struct"),
+			},
+		}, {
+			Name:  proto.String("Inner"),
+			Field: []*descriptorpb.FieldDescriptorProto{testField("on", 1, optional, descriptorpb.FieldDescriptorProto_TYPE_BOOL, "")},
+		}},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Svc"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{Name: proto.String("Call"), InputType: proto.String(".test.Req"), OutputType: proto.String(".test.Req")},
+			},
+		}},
+	}
+	set := &descriptorpb.FileDescriptorSet{}
+	if withImports {
+		// what protoc --include_imports adds
+		set.File = append(set.File,
+			protodesc.ToFileDescriptorProto(timestamppb.File_google_protobuf_timestamp_proto),
+			protodesc.ToFileDescriptorProto(durationpb.File_google_protobuf_duration_proto),
+			protodesc.ToFileDescriptorProto(wrapperspb.File_google_protobuf_wrappers_proto),
+			protodesc.ToFileDescriptorProto(structpb.File_google_protobuf_struct_proto))
+	}
+	set.File = append(set.File, file)
+	b, err := proto.Marshal(set)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestParseProtoDescriptors(t *testing.T) {
+	d, err := ParseProtoDescriptors(testDescriptorSet(true))
+	if err != nil {
+		t.Fatalf("ParseProtoDescriptors err = %v", err)
+	}
+	if input, err := d.InputType("test.Svc/Call"); err != nil || input != "test.Req" {
+		t.Errorf("InputType = %q, %v", input, err)
+	}
+	for _, method := range []string{"test.Svc/Nope", "test.Nope/Call", "test.Req/Call", "Call"} {
+		if _, err = d.InputType(method); err == nil {
+			t.Errorf("InputType(%q) want err, got nil", method)
+		}
+	}
+
+	if _, err = ParseProtoDescriptors(testDescriptorSet(false)); err == nil || !strings.Contains(err.Error(), "--include_imports") {
+		t.Errorf("without the imports: err = %v, want an --include_imports hint", err)
+	}
+	if _, err = ParseProtoDescriptors([]byte("not a descriptor set")); err == nil {
+		t.Error("want err for garbage, got nil")
+	}
+	if _, err = ParseProtoDescriptors(nil); err == nil {
+		t.Error("want err for an empty set, got nil")
+	}
+}
+
+func TestProtoDescriptors_EncodeJSON(t *testing.T) {
+	d, err := ParseProtoDescriptors(testDescriptorSet(true))
+	if err != nil {
+		t.Fatalf("ParseProtoDescriptors err = %v", err)
+	}
+
+	// the well-known types are encoded as the messages they map to
+	wkt := func(number protowire.Number, m proto.Message) []byte {
+		b, err := proto.MarshalOptions{Deterministic: true}.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return protowire.AppendBytes(protowire.AppendTag(nil, number, protowire.BytesType), b)
+	}
+	double := func(v float64) []byte {
+		return protowire.AppendFixed64(protowire.AppendTag(nil, 6, protowire.Fixed64Type), math.Float64bits(v))
+	}
+	extra, _ := structpb.NewStruct(map[string]interface{}{"a": 1, "b": []interface{}{"x", true}})
+	cases := []struct {
+		name string
+		json string
+		want []byte
+	}{
+		{"scalars", `{"name":"a","count":3,"ids":[1,"300"],"kind":"FAST","inner":{"on":true}}`, []byte{
+			0x0a, 1, 'a', // name
+			0x10, 3, // count
+			0x1a, 3, 1, 0xac, 0x02, // packed ids
+			0x20, 1, // kind
+			0x2a, 2, 0x08, 1, // inner.on
+		}},
+		{"enum number", `{"kind":1}`, []byte{0x20, 1}},
+		{"NaN", `{"ratio":"NaN"}`, double(math.NaN())},
+		{"Infinity", `{"ratio":"Infinity"}`, double(math.Inf(1))},
+		{"-Infinity", `{"ratio":"-Infinity"}`, double(math.Inf(-1))},
+		{"Timestamp", `{"at":"2024-01-02T03:04:05.5Z"}`, wkt(7, timestamppb.New(time.Date(2024, 1, 2, 3, 4, 5, 5e8, time.UTC)))},
+		{"Duration", `{"timeout":"1.5s"}`, wkt(8, durationpb.New(1500*time.Millisecond))},
+		{"wrapper", `{"limit":5}`, wkt(9, wrapperspb.Int32(5))},
+		{"Struct", `{"extra":{"a":1,"b":["x",true]}}`, wkt(10, extra)},
+	}
+	for _, tc := range cases {
+		got, err := d.EncodeJSON("test.Req", []byte(tc.json))
+		if err != nil {
+			t.Errorf("%v: EncodeJSON err = %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Errorf("%v: EncodeJSON = % x, want % x", tc.name, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{`{"nope":1}`, `{"kind":"SLOW"}`, `{"count":"x"}`, `[1]`, `{"ratio":"nan"}`,
+		`{"at":"yesterday"}`, `{"timeout":"1.5"}`, `{"name":"a"`} {
+		if _, err = d.EncodeJSON("test.Req", []byte(bad)); err == nil {
+			t.Errorf("EncodeJSON(%s) want err, got nil", bad)
+		}
+	}
+	for _, msgType := range []string{"test.Nope", "test.Kind", "test.Svc"} {
+		if _, err = d.EncodeJSON(msgType, []byte(`{}`)); err == nil {
+			t.Errorf("EncodeJSON(%v) want err, got nil", msgType)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package loader
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	histo "github.com/HdrHistogram/hdrhistogram-go"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyCfg an outbound proxy - http(s):// proxies get plain http requests as is and tunnel https with CONNECT,
+// socks5:// proxies tunnel everything. Credentials are taken from the url user info.
+type ProxyCfg struct {
+	url    *url.URL
+	bypass []string
+}
+
+// ProxyStats the connections made through the proxy
+type ProxyStats struct {
+	Connects    int
+	ConnectHist *histo.Histogram // time to a ready connection: the proxy connection, CONNECT or SOCKS handshake
+}
+
+// dialFunc the signature of net.Dialer.DialContext
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+func (d dialFunc) Dial(network, addr string) (net.Conn, error) {
+	return d(context.Background(), network, addr)
+}
+
+func (d dialFunc) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return d(ctx, network, addr)
+}
+
+// NewProxyCfg proxyUrl is http://, https://, socks5:// or socks5h://. bypass is a comma separated list of hosts that
+// are connected to directly: host names (matching their sub domains too, *.example.com and .example.com only match
+// the sub domains), IP addresses and CIDR ranges, any of them optionally with a :port. * bypasses everything.
+func NewProxyCfg(proxyUrl, bypass string) (*ProxyCfg, error) {
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q, expected http, https or socks5", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy url %q has no host", proxyUrl)
+	}
+	p := &ProxyCfg{url: u}
+	for _, b := range strings.Split(bypass, ",") {
+		if b = strings.ToLower(strings.TrimSpace(b)); b != "" {
+			p.bypass = append(p.bypass, b)
+		}
+	}
+	return p, nil
+}
+
+// WithProxy sends the requests through an outbound proxy
+func WithProxy(p *ProxyCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.proxy = p
+	}
+}
+
+func withProxy(p *ProxyCfg) clientOption {
+	return func(o *clientOpts) {
+		o.proxy = p
+	}
+}
+
+func newProxyStats(duration int) *ProxyStats {
+	return &ProxyStats{ConnectHist: newHistogram(duration)}
+}
+
+func (p *ProxyStats) merge(o *ProxyStats) {
+	p.Connects += o.Connects
+	p.ConnectHist.Merge(o.ConnectHist)
+}
+
+// bypassed true when host:port should be connected to directly
+func (p *ProxyCfg) bypassed(host, port string) bool {
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+	for _, b := range p.bypass {
+		if b == "*" {
+			return true
+		}
+		pattern := b
+		if h, bp, err := net.SplitHostPort(b); err == nil {
+			if bp != port {
+				continue
+			}
+			pattern = h
+		}
+		if _, cidr, err := net.ParseCIDR(pattern); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if bip := net.ParseIP(pattern); bip != nil {
+			if ip != nil && bip.Equal(ip) {
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(pattern, "*.") || strings.HasPrefix(pattern, ".") {
+			if strings.HasSuffix(host, strings.TrimPrefix(pattern, "*")) {
+				return true
+			}
+		} else if host == pattern || strings.HasSuffix(host, "."+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyFunc the http.Transport Proxy function
+func (p *ProxyCfg) proxyFunc(req *http.Request) (*url.URL, error) {
+	port := req.URL.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[req.URL.Scheme]
+	}
+	if p.bypassed(req.URL.Hostname(), port) {
+		return nil, nil
+	}
+	return p.url, nil
+}
+
+// recordProxyConnect records the time to set up a new connection through the proxy. The connection is ready when its
+// TLS handshake with the target starts (or when it is used, without TLS).
+func (cfg *LoadCfg) recordProxyConnect(stats *RequesterStats, res *reqResult) {
+	if cfg.proxy == nil || res.connectStart.IsZero() {
+		return
+	}
+	u, err := url.Parse(cfg.testUrl)
+	if err != nil {
+		return
+	}
+	req := &http.Request{URL: u}
+	if proxyUrl, _ := cfg.proxy.proxyFunc(req); proxyUrl == nil {
+		return
+	}
+	ready := res.gotConn
+	if !res.tlsStart.IsZero() {
+		ready = res.tlsStart
+	}
+	if stats.Proxy == nil {
+		stats.Proxy = newProxyStats(cfg.duration)
+	}
+	stats.Proxy.Connects++
+	stats.Proxy.ConnectHist.RecordValue(ready.Sub(res.connectStart).Microseconds())
+}
+
+// proxyAddr the host:port of the proxy
+func (p *ProxyCfg) proxyAddr() string {
+	if p.url.Port() != "" {
+		return p.url.Host
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[p.url.Scheme]
+	return net.JoinHostPort(p.url.Hostname(), port)
+}
+
+// wrapDial a dial function that connects through the proxy, for transports that do not support proxies themselves
+func (p *ProxyCfg) wrapDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, _ := net.SplitHostPort(addr)
+		if p.bypassed(host, port) {
+			return dial(ctx, network, addr)
+		}
+		if strings.HasPrefix(p.url.Scheme, "socks5") {
+			d, err := proxy.FromURL(p.url, dial)
+			if err != nil {
+				return nil, err
+			}
+			return d.(proxy.ContextDialer).DialContext(ctx, network, addr)
+		}
+		return p.connect(ctx, dial, addr)
+	}
+}
+
+// connect opens a CONNECT tunnel to addr through an http(s) proxy
+func (p *ProxyCfg) connect(ctx context.Context, dial dialFunc, addr string) (net.Conn, error) {
+	conn, err := dial(ctx, "tcp", p.proxyAddr())
+	if err != nil {
+		return nil, err
+	}
+	if p.url.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: p.url.Hostname()})
+		if err = tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tc
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	req := &http.Request{Method: http.MethodConnect, URL: &url.URL{Opaque: addr}, Host: addr, Header: make(http.Header)}
+	if u := p.url.User; u != nil {
+		pass, _ := u.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(u.Username()+":"+pass)))
+	}
+	if err = req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// the body of a successful CONNECT response is the tunnel, it is not closed
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT: %v", resp.Status)
+	}
+	return conn, nil
+}
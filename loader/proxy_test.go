@@ -0,0 +1,267 @@
+package loader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+// newHTTPTestProxy a forward proxy that requires user:pass, counting the requests it forwarded and the tunnels it opened
+func newHTTPTestProxy(t *testing.T, forwarded, tunnels *int32) string {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		if r.Method == http.MethodConnect {
+			atomic.AddInt32(tunnels, 1)
+			target, err := net.Dial("tcp", r.Host)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			conn, rw, _ := w.(http.Hijacker).Hijack()
+			go func() {
+				_, _ = io.Copy(target, rw)
+				target.Close()
+			}()
+			_, _ = io.Copy(conn, target)
+			conn.Close()
+			return
+		}
+		atomic.AddInt32(forwarded, 1)
+		r.RequestURI = ""
+		r.Header.Del("Proxy-Authorization")
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(ts.Close)
+	return ts.URL
+}
+
+// newSOCKS5TestProxy a SOCKS5 proxy without authentication, counting the connections it opened
+func newSOCKS5TestProxy(t *testing.T, tunnels *int32) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				rd := bufio.NewReader(c)
+				hdr := make([]byte, 2)
+				if _, err := io.ReadFull(rd, hdr); err != nil {
+					return
+				}
+				_, _ = rd.Discard(int(hdr[1])) // offered methods
+				_, _ = c.Write([]byte{5, 0})
+				req := make([]byte, 4)
+				if _, err := io.ReadFull(rd, req); err != nil {
+					return
+				}
+				var host string
+				switch req[3] {
+				case 1:
+					ip := make([]byte, 4)
+					_, _ = io.ReadFull(rd, ip)
+					host = net.IP(ip).String()
+				case 3:
+					n, _ := rd.ReadByte()
+					name := make([]byte, n)
+					_, _ = io.ReadFull(rd, name)
+					host = string(name)
+				default:
+					return
+				}
+				port := make([]byte, 2)
+				_, _ = io.ReadFull(rd, port)
+				target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+				if err != nil {
+					_, _ = c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+					return
+				}
+				defer target.Close()
+				atomic.AddInt32(tunnels, 1)
+				_, _ = c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				go func() { _, _ = io.Copy(target, rd) }()
+				_, _ = io.Copy(c, target)
+			}()
+		}
+	}()
+	return "socks5://" + l.Addr().String()
+}
+
+func TestNewProxyCfg(t *testing.T) {
+	for _, bad := range []string{"ftp://proxy:21", "http://", "::"} {
+		if _, err := NewProxyCfg(bad, ""); err == nil {
+			t.Errorf("NewProxyCfg(%q) want err, got nil", bad)
+		}
+	}
+	p, err := NewProxyCfg("http://proxy:3128", "internal.example, .corp, 10.0.0.0/8, 192.168.1.1, localhost:8080")
+	if err != nil {
+		t.Fatalf("NewProxyCfg err = %v", err)
+	}
+	cases := []struct {
+		host, port string
+		want       bool
+	}{
+		{"internal.example", "443", true},
+		{"api.internal.example", "443", true},
+		{"notinternal.example", "443", false},
+		{"a.corp", "80", true},
+		{"corp", "80", false},
+		{"10.1.2.3", "80", true},
+		{"11.1.2.3", "80", false},
+		{"192.168.1.1", "80", true},
+		{"localhost", "8080", true},
+		{"localhost", "8081", false},
+		{"example.com", "443", false},
+	}
+	for _, tc := range cases {
+		if got := p.bypassed(tc.host, tc.port); got != tc.want {
+			t.Errorf("bypassed(%v, %v) = %v, want %v", tc.host, tc.port, got, tc.want)
+		}
+	}
+}
+
+func TestRunSingleLoadSession_Proxy(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(plain.Close)
+	tlsServer := httptest.NewUnstartedServer(plain.Config.Handler)
+	tlsServer.EnableHTTP2 = true
+	tlsServer.StartTLS()
+	t.Cleanup(tlsServer.Close)
+
+	var forwarded, tunnels, socksTunnels int32
+	httpProxy := newHTTPTestProxy(t, &forwarded, &tunnels)
+	authProxy := "http://user:pass@" + httpProxy[len("http://"):]
+	socksProxy := newSOCKS5TestProxy(t, &socksTunnels)
+
+	cases := []struct {
+		name    string
+		url     string
+		proxy   string
+		proto   string
+		counter *int32
+	}{
+		{"http_forward", plain.URL, authProxy, PROTO_AUTO, &forwarded},
+		{"http_connect", tlsServer.URL, authProxy, PROTO_AUTO, &tunnels},
+		{"http_connect_h2", tlsServer.URL, authProxy, PROTO_H2, &tunnels},
+		{"socks5", plain.URL, socksProxy, PROTO_AUTO, &socksTunnels},
+		{"socks5_tls", tlsServer.URL, socksProxy, PROTO_AUTO, &socksTunnels},
+		{"socks5_h2", tlsServer.URL, socksProxy, PROTO_H2, &socksTunnels},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			before := atomic.LoadInt32(tc.counter)
+			p, err := NewProxyCfg(tc.proxy, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch := make(chan *RequesterStats, 1)
+			cfg := NewLoadCfg(1, 1, tc.url, "", "GET", "", nil, ch, 1000, true, false, false, true, "", "", "", true,
+				WithProxy(p), WithProto(tc.proto))
+
+			stats := runSession(t, cfg, ch)
+
+			if stats.NumRequests == 0 || stats.NumErrs != 0 {
+				t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+			}
+			if atomic.LoadInt32(tc.counter) == before {
+				t.Error("the proxy was not used")
+			}
+			if stats.Proxy == nil || stats.Proxy.Connects == 0 || stats.Proxy.ConnectHist.TotalCount() == 0 {
+				t.Errorf("Proxy stats = %+v, want the proxy connections recorded", stats.Proxy)
+			}
+		})
+	}
+}
+
+func TestRunSingleLoadSession_ProxyAuthRequired(t *testing.T) {
+	var forwarded, tunnels int32
+	p, _ := NewProxyCfg(newHTTPTestProxy(t, &forwarded, &tunnels), "")
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, "http://example.invalid/", "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", true, WithProxy(p))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumRequests != 0 || errorCount(stats, ERR_STATUS) == 0 {
+		t.Errorf("NumRequests = %d, Errors = %v, want 407 errors", stats.NumRequests, stats.Errors)
+	}
+}
+
+func TestRunSingleLoadSession_ProxyBypass(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(ts.Close)
+
+	// nothing listens on the proxy address, the requests only succeed when they bypass it
+	p, _ := NewProxyCfg("http://127.0.0.1:1", "127.0.0.0/8")
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", true, WithProxy(p))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumRequests == 0 || stats.NumErrs != 0 || stats.Proxy != nil {
+		t.Errorf("NumRequests = %d, NumErrs = %d, Proxy = %+v", stats.NumRequests, stats.NumErrs, stats.Proxy)
+	}
+}
+
+func TestRunSingleLoadSession_ProxySessionDial(t *testing.T) {
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	t.Cleanup(plain.Close)
+	wsUrl := newWSTestServer(t, echoHandler)
+
+	var forwarded, tunnels int32
+	httpProxy := newHTTPTestProxy(t, &forwarded, &tunnels)
+	p, _ := NewProxyCfg("http://user:pass@"+httpProxy[len("http://"):], "")
+	for _, tc := range []struct {
+		name    string
+		url     string
+		options []Option
+	}{
+		{"pipeline", plain.URL, []Option{WithPipeline(4)}},
+		{"websocket", wsUrl, nil},
+	} {
+		before := atomic.LoadInt32(&tunnels)
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, tc.url, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", true,
+			append(tc.options, WithProxy(p))...)
+
+		stats := runSession(t, cfg, ch)
+
+		if stats.NumRequests == 0 || stats.NumErrs != 0 {
+			t.Fatalf("%v: NumRequests = %d, NumErrs = %d, Errors = %v", tc.name, stats.NumRequests, stats.NumErrs,
+				stats.Errors)
+		}
+		if atomic.LoadInt32(&tunnels) == before {
+			t.Errorf("%v: the connection was not made through the proxy", tc.name)
+		}
+	}
+}
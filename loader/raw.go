@@ -0,0 +1,535 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ENGINE_NET   = "net"   // net/http
+	ENGINE_RAW   = "raw"   // the request serialized once, written as is on pooled HTTP/1.1 connections
+	ENGINE_EPOLL = "epoll" // the raw engine driven by a few epoll event loops, Linux only
+)
+
+var (
+	errRawMalformed = errors.New("malformed HTTP response")
+	errRawTooLong   = errors.New("HTTP response line too long")
+
+	rawHTTP1            = []byte("HTTP/1.")
+	rawContentLength    = []byte("content-length")
+	rawTransferEncoding = []byte("transfer-encoding")
+	rawConnection       = []byte("connection")
+	rawChunked          = []byte("chunked")
+	rawClose            = []byte("close")
+	rawKeepAlive        = []byte("keep-alive")
+)
+
+// RawCfg the raw HTTP/1.1 engine. The request is serialized once, with the headers in the given order and case, and
+// written as is on connections shared by all the goroutines. The responses are parsed without allocating.
+type RawCfg struct {
+	headers [][2]string // name and value, in order
+
+	once    sync.Once
+	req     *http.Request // the request the wire bytes were made from, for dialing
+	wire    []byte
+	wireErr error
+
+	mu   sync.Mutex
+	idle []*rawConn
+}
+
+type rawConn struct {
+	conn      net.Conn
+	rd        *bufio.Reader
+	idleSince time.Time // when it was put back in the pool
+}
+
+// rawResponse the parts of a response the statistics need
+type rawResponse struct {
+	status int
+	minor  int   // the HTTP/1.x minor version
+	size   int64 // the bytes of the status line, headers and body, including any chunked encoding
+	close  bool  // the server closes the connection after the response
+}
+
+// NewRawCfg headers are "Name: value" lines, sent in the given order and with the given case. Without any, the
+// headers of the LoadCfg are sent sorted by name.
+func NewRawCfg(headers []string) (*RawCfg, error) {
+	r := &RawCfg{}
+	for _, h := range headers {
+		name, value, ok := strings.Cut(h, ":")
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if !ok || name == "" || strings.ContainsAny(name, " \t\r\n") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid header %q, expected Name: value", h)
+		}
+		r.headers = append(r.headers, [2]string{name, value})
+	}
+	return r, nil
+}
+
+// WithRaw sends plain HTTP/1.1 requests with the raw engine instead of net/http
+func WithRaw(r *RawCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.raw = r
+	}
+}
+
+// ValidEngine checks the value of an engine selection
+func ValidEngine(engine string) error {
+	switch engine {
+	case ENGINE_NET, ENGINE_RAW:
+		return nil
+	case ENGINE_EPOLL:
+		if !epollSupported {
+			return errors.New("the epoll engine is only available on Linux")
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown engine %q, expected %v, %v or %v", engine, ENGINE_NET, ENGINE_RAW, ENGINE_EPOLL)
+}
+
+// request the request and its wire bytes, serialized on first use
+func (r *RawCfg) request(cfg *LoadCfg) (*http.Request, []byte, error) {
+	r.once.Do(func() {
+		r.req, r.wire, r.wireErr = r.serialize(cfg)
+	})
+	return r.req, r.wire, r.wireErr
+}
+
+func (r *RawCfg) serialize(cfg *LoadCfg) (*http.Request, []byte, error) {
+	req, err := http.NewRequest(cfg.method, escapeUrlStr(cfg.testUrl), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return nil, nil, fmt.Errorf("the raw engine requires an http or https url, got %q", req.URL.Scheme)
+	}
+	headers := r.headers
+	if len(headers) == 0 {
+		for name, value := range cfg.header {
+			headers = append(headers, [2]string{strings.TrimSpace(name), strings.TrimSpace(value)})
+		}
+		sort.Slice(headers, func(i, j int) bool { return headers[i][0] < headers[j][0] })
+	}
+	has := func(name string) bool {
+		for _, h := range headers {
+			if strings.EqualFold(h[0], name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%v %v HTTP/1.1\r\n", cfg.method, req.URL.RequestURI())
+	if !has("Host") {
+		host := req.URL.Host
+		if cfg.host != "" {
+			host = cfg.host
+		}
+		fmt.Fprintf(&buf, "Host: %v\r\n", host)
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&buf, "%v: %v\r\n", h[0], h[1])
+	}
+	if !has("User-Agent") {
+		fmt.Fprintf(&buf, "User-Agent: %v\r\n", USER_AGENT)
+	}
+	if !has("Content-Length") && (len(cfg.reqBody) > 0 || cfg.method == "POST" || cfg.method == "PUT" || cfg.method == "PATCH") {
+		fmt.Fprintf(&buf, "Content-Length: %v\r\n", len(cfg.reqBody))
+	}
+	if cfg.disableKeepAlive && !has("Connection") {
+		buf.WriteString("Connection: close\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.WriteString(cfg.reqBody)
+	return req, buf.Bytes(), nil
+}
+
+// get an idle connection, or a new one. reused is false for a new connection.
+func (r *RawCfg) get(cfg *LoadCfg, req *http.Request, stats *RequesterStats) (c *rawConn, reused bool, err error) {
+	r.mu.Lock()
+	if n := len(r.idle); n > 0 {
+		c = r.idle[n-1]
+		r.idle = r.idle[:n-1]
+	}
+	r.mu.Unlock()
+	if c != nil {
+		trackRequest(c.conn, time.Since(c.idleSince))
+		return c, true, nil
+	}
+	conn, err := cfg.pipelineDial(req)
+	if err != nil {
+		return nil, false, err
+	}
+	stats.ConnsOpened++
+	if tc, ok := conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		stats.recordTLS(&state)
+	}
+	trackRequest(conn, 0)
+	return &rawConn{conn: conn, rd: bufio.NewReader(conn)}, false, nil
+}
+
+func (r *RawCfg) put(c *rawConn) {
+	c.idleSince = time.Now()
+	r.mu.Lock()
+	r.idle = append(r.idle, c)
+	r.mu.Unlock()
+}
+
+// closeIdle closes the idle connections, once all the sessions ended
+func (r *RawCfg) closeIdle() {
+	r.mu.Lock()
+	idle := r.idle
+	r.idle = nil
+	r.mu.Unlock()
+	for _, c := range idle {
+		c.conn.Close()
+	}
+}
+
+// runRawSession the RunSingleLoadSession loop of the raw engine
+func (cfg *LoadCfg) runRawSession(stats *RequesterStats, start time.Time) {
+	req, wire, err := cfg.raw.request(cfg)
+	if err != nil {
+		stats.recordError(err)
+		return
+	}
+	timeout := time.Millisecond * time.Duration(cfg.timeoutms)
+	head := cfg.method == http.MethodHead
+	var resp rawResponse
+
+	for !cfg.done(start) {
+		stats.tick()
+		c, reused, err := cfg.raw.get(cfg, req, stats)
+		if err != nil {
+			stats.recordError(err)
+			continue
+		}
+		reqStart := time.Now()
+		err = c.roundTrip(wire, head, reqStart.Add(timeout), &resp)
+		if err != nil && reused && resp.size == 0 && !isTimeout(err) {
+			// the server closed the idle connection, as net/http does the request is retried on a new one
+			c.conn.Close()
+			if c, _, err = cfg.raw.get(cfg, req, stats); err == nil {
+				reqStart = time.Now()
+				err = c.roundTrip(wire, head, reqStart.Add(timeout), &resp)
+			}
+		}
+		reqDur := time.Since(reqStart)
+		if err != nil {
+			stats.recordError(err)
+			if c != nil {
+				c.conn.Close()
+			}
+			continue
+		}
+		if resp.close || cfg.disableKeepAlive {
+			if resp.close {
+				trackServerClose(c.conn)
+			}
+			c.conn.Close()
+		} else {
+			cfg.raw.put(c)
+		}
+		stats.TotReqSize += int64(len(wire))
+		stats.recordRaw(&resp, reqDur, cfg.success)
+	}
+}
+
+// recordRaw records a response of the raw engines
+func (stats *RequesterStats) recordRaw(resp *rawResponse, reqDur time.Duration, success *SuccessCfg) {
+	if resp.minor == 0 {
+		stats.Protocols["HTTP/1.0"]++
+	} else {
+		stats.Protocols["HTTP/1.1"]++
+	}
+	ok := success.ok(resp.status)
+	stats.recordStatus(resp.status, ok, reqDur)
+	if !ok {
+		stats.recordError(&StatusError{Code: resp.status})
+		return
+	}
+	stats.TotRespSize += resp.size
+	stats.TotDuration += reqDur
+	stats.recordLatency(reqDur)
+	stats.NumRequests++
+}
+
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// roundTrip writes the request and reads its response
+func (c *rawConn) roundTrip(wire []byte, head bool, deadline time.Time, resp *rawResponse) error {
+	*resp = rawResponse{}
+	c.conn.SetDeadline(deadline)
+	if _, err := c.conn.Write(wire); err != nil {
+		return err
+	}
+	return readRawResponse(c.rd, head, resp)
+}
+
+// readRawResponse reads a response, skipping interim (1xx) responses, and discards its body
+func readRawResponse(rd *bufio.Reader, head bool, resp *rawResponse) error {
+	var p rawParser
+	p.reset(head)
+	defer func() { *resp = p.resp }()
+	for {
+		if _, err := rd.Peek(1); err != nil {
+			return p.respErr(p.eof(err))
+		}
+		data, _ := rd.Peek(rd.Buffered())
+		n, done, err := p.feed(data)
+		rd.Discard(n)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// the states of rawParser
+const (
+	rawStatus     = iota // expecting the status line
+	rawHeader            // reading the header lines
+	rawBody              // reading a body of a known length
+	rawChunkSize         // expecting a chunk size line
+	rawChunkData         // reading the data of a chunk
+	rawChunkEnd          // expecting the line ending after the data of a chunk
+	rawTrailer           // reading the trailer lines, after the last chunk
+	rawUntilClose        // reading a body that ends with the connection
+	rawDone
+)
+
+// the longest status, header or chunk size line
+const maxRawLine = 4096
+
+// rawParser an incremental response parser, fed with the data as it arrives
+type rawParser struct {
+	state         int
+	head          bool // the response to a HEAD request, without a body
+	contentLength int
+	chunked       bool
+	remain        int    // the bytes left of the body or chunk
+	partial       []byte // the start of a line split between reads
+	resp          rawResponse
+}
+
+func (p *rawParser) reset(head bool) {
+	*p = rawParser{head: head, partial: p.partial[:0]}
+}
+
+// feed parses the next part of the response. It returns the bytes consumed, and whether the response is complete.
+// The bytes after a complete response are not consumed.
+func (p *rawParser) feed(data []byte) (n int, done bool, err error) {
+	for n < len(data) && p.state != rawDone {
+		switch p.state {
+		case rawBody, rawChunkData:
+			take := p.remain
+			if take > len(data)-n {
+				take = len(data) - n
+			}
+			n += take
+			p.remain -= take
+			p.resp.size += int64(take)
+			if p.remain > 0 {
+				continue
+			}
+			if p.state == rawBody {
+				p.state = rawDone
+			} else {
+				p.state = rawChunkEnd
+			}
+		case rawUntilClose:
+			p.resp.size += int64(len(data) - n)
+			n = len(data)
+		default:
+			line, used, ok, err := p.nextLine(data[n:])
+			n += used
+			if err != nil {
+				return n, false, err
+			}
+			if !ok {
+				continue
+			}
+			if err = p.line(line); err != nil {
+				return n, false, err
+			}
+		}
+	}
+	return n, p.state == rawDone, nil
+}
+
+// eof ends the response when the connection ends, which completes a response that is read until then
+func (p *rawParser) eof(err error) error {
+	if err != io.EOF {
+		return err
+	}
+	if p.state == rawUntilClose {
+		p.state = rawDone
+		return nil
+	}
+	if p.resp.size > 0 || len(p.partial) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return io.EOF
+}
+
+// respErr marks an error by the part of the response it happened in, a *headerError before the headers were
+// complete and a *bodyError while reading the body
+func (p *rawParser) respErr(err error) error {
+	switch {
+	case err == nil || p.state == rawDone:
+		return err
+	case p.state < rawBody:
+		return &headerError{err}
+	}
+	return &bodyError{err}
+}
+
+// nextLine the next complete line of data without its line ending, joined with its start from the previous data
+func (p *rawParser) nextLine(data []byte) (line []byte, n int, ok bool, err error) {
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		if len(p.partial)+len(data) > maxRawLine {
+			return nil, len(data), false, errRawTooLong
+		}
+		p.partial = append(p.partial, data...)
+		return nil, len(data), false, nil
+	}
+	n = i + 1
+	line = data[:n]
+	if len(p.partial) > 0 {
+		p.partial = append(p.partial, line...)
+		line = p.partial
+		p.partial = p.partial[:0]
+	}
+	if len(line) > maxRawLine {
+		return nil, n, false, errRawTooLong
+	}
+	p.resp.size += int64(len(line))
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, n, true, nil
+}
+
+// line handles a complete line in the current state
+func (p *rawParser) line(line []byte) (err error) {
+	switch p.state {
+	case rawStatus:
+		// HTTP/1.x 200 OK
+		if len(line) < 12 || !bytes.HasPrefix(line, rawHTTP1) || line[8] != ' ' || line[7] < '0' || line[7] > '9' {
+			return errRawMalformed
+		}
+		p.resp.minor = int(line[7] - '0')
+		if p.resp.status, err = parseRawInt(line[9:12], 10); err != nil {
+			return err
+		}
+		p.resp.close = p.resp.minor == 0
+		p.contentLength, p.chunked = -1, false
+		p.state = rawHeader
+	case rawHeader:
+		if len(line) == 0 {
+			p.endHeaders()
+			return nil
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			return errRawMalformed
+		}
+		name, value := line[:colon], bytes.TrimSpace(line[colon+1:])
+		switch {
+		case bytes.EqualFold(name, rawContentLength):
+			if p.contentLength, err = parseRawInt(value, 10); err != nil {
+				return err
+			}
+		case bytes.EqualFold(name, rawTransferEncoding):
+			p.chunked = bytes.EqualFold(value, rawChunked)
+		case bytes.EqualFold(name, rawConnection):
+			if bytes.EqualFold(value, rawClose) {
+				p.resp.close = true
+			} else if bytes.EqualFold(value, rawKeepAlive) {
+				p.resp.close = false
+			}
+		}
+	case rawChunkSize:
+		if i := bytes.IndexByte(line, ';'); i >= 0 {
+			line = line[:i] // chunk extensions
+		}
+		if p.remain, err = parseRawInt(bytes.TrimSpace(line), 16); err != nil {
+			return err
+		}
+		p.state = rawChunkData
+		if p.remain == 0 {
+			p.state = rawTrailer
+		}
+	case rawChunkEnd:
+		if len(line) != 0 {
+			return errRawMalformed
+		}
+		p.state = rawChunkSize
+	case rawTrailer:
+		if len(line) == 0 {
+			p.state = rawDone
+		}
+	}
+	return nil
+}
+
+// endHeaders chooses how the body is read, once the headers are complete
+func (p *rawParser) endHeaders() {
+	status := p.resp.status
+	switch {
+	case status/100 == 1 && status != http.StatusSwitchingProtocols:
+		// an interim response, the final one follows
+		p.state = rawStatus
+	case p.head || status/100 == 1 || status == http.StatusNoContent || status == http.StatusNotModified:
+		p.state = rawDone
+	case p.chunked:
+		p.state = rawChunkSize
+	case p.contentLength > 0:
+		p.state, p.remain = rawBody, p.contentLength
+	case p.contentLength == 0:
+		p.state = rawDone
+	default:
+		p.state = rawUntilClose
+		p.resp.close = true
+	}
+}
+
+// parseRawInt parses a non negative decimal or hex number without allocating
+func parseRawInt(b []byte, base int) (int, error) {
+	if len(b) == 0 || len(b) > 15 {
+		return 0, errRawMalformed
+	}
+	n := 0
+	for _, c := range b {
+		var d int
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case base == 16 && c >= 'a' && c <= 'f':
+			d = int(c-'a') + 10
+		case base == 16 && c >= 'A' && c <= 'F':
+			d = int(c-'A') + 10
+		default:
+			return 0, errRawMalformed
+		}
+		n = n*base + d
+	}
+	return n, nil
+}
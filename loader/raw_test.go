@@ -0,0 +1,222 @@
+package loader
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRawCfg_serialize(t *testing.T) {
+	r, err := NewRawCfg([]string{"x-lower: a", "X-UPPER:b ", "Accept: */*"})
+	if err != nil {
+		t.Fatalf("NewRawCfg err = %v", err)
+	}
+	cfg := NewLoadCfg(1, 1, "http://example.com:8080/path?q=1", "hello", "POST", "", nil, nil, 1000, true, false, true, false,
+		"", "", "", false, WithRaw(r))
+
+	_, wire, err := r.request(cfg)
+	if err != nil {
+		t.Fatalf("request err = %v", err)
+	}
+	want := "POST /path?q=1 HTTP/1.1\r\nHost: example.com:8080\r\nx-lower: a\r\nX-UPPER: b\r\nAccept: */*\r\n" +
+		"User-Agent: go-wrk\r\nContent-Length: 5\r\nConnection: close\r\n\r\nhello"
+	if string(wire) != want {
+		t.Errorf("wire = %q, want %q", wire, want)
+	}
+
+	for _, bad := range []string{"no colon", ": empty", "bad name: x"} {
+		if _, err = NewRawCfg([]string{bad}); err == nil {
+			t.Errorf("NewRawCfg(%q) want err, got nil", bad)
+		}
+	}
+}
+
+func TestReadRawResponse(t *testing.T) {
+	cases := []struct {
+		name   string
+		head   bool
+		resp   string
+		status int
+		size   int64
+		close  bool
+		err    bool
+	}{
+		{name: "content length", resp: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello", status: 200, size: 43},
+		{name: "chunked", resp: "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5;ext=1\r\nhello\r\n3\r\nabc\r\n0\r\nX-Trailer: t\r\n\r\n",
+			status: 200, size: 90},
+		{name: "interim", resp: "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 204 No Content\r\n\r\n", status: 204, size: 52},
+		{name: "head", head: true, resp: "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\n", status: 200, size: 38},
+		{name: "connection close", resp: "HTTP/1.1 404 Not Found\r\nConnection: close\r\nContent-Length: 0\r\n\r\n", status: 404,
+			size: 64, close: true},
+		{name: "until eof", resp: "HTTP/1.0 200 OK\r\n\r\nhello", status: 200, size: 24, close: true},
+		{name: "keep alive 1.0", resp: "HTTP/1.0 200 OK\r\nConnection: Keep-Alive\r\nContent-Length: 0\r\n\r\n", status: 200, size: 62},
+		{name: "malformed", resp: "HTTP/2 200\r\n\r\n", err: true},
+		{name: "bad length", resp: "HTTP/1.1 200 OK\r\nContent-Length: -1\r\n\r\n", err: true},
+		{name: "truncated", resp: "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello", err: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var resp rawResponse
+			err := readRawResponse(bufio.NewReader(strings.NewReader(tc.resp)), tc.head, &resp)
+			if tc.err {
+				if err == nil {
+					t.Errorf("want err, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if resp.status != tc.status || resp.size != tc.size || resp.close != tc.close {
+				t.Errorf("got %+v, want status %d size %d close %v", resp, tc.status, tc.size, tc.close)
+			}
+		})
+	}
+}
+
+func TestReadRawResponse_NoAllocs(t *testing.T) {
+	const responses = "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello" +
+		"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n0\r\n\r\n"
+	src := strings.NewReader(responses)
+	rd := bufio.NewReader(src)
+	var resp rawResponse
+	allocs := testing.AllocsPerRun(100, func() {
+		src.Reset(responses)
+		rd.Reset(src)
+		for i := 0; i < 2; i++ {
+			if err := readRawResponse(rd, false, &resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("readRawResponse allocs = %v, want 0", allocs)
+	}
+}
+
+func TestRunSingleLoadSession_Raw(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Fail") != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	r, _ := NewRawCfg(nil)
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false,
+		"", "", "", false, WithRaw(r))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumRequests == 0 || stats.NumErrs != 0 {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+	if stats.ConnsOpened != 1 {
+		t.Errorf("ConnsOpened = %d, want a single kept alive connection", stats.ConnsOpened)
+	}
+	if stats.Protocols["HTTP/1.1"] != stats.NumRequests {
+		t.Errorf("Protocols = %v, want HTTP/1.1=%d", stats.Protocols, stats.NumRequests)
+	}
+	if stats.Histogram.TotalCount() != int64(stats.NumRequests) || stats.TotRespSize <= int64(2*stats.NumRequests) {
+		t.Errorf("Histogram count = %d, TotRespSize = %d for %d requests", stats.Histogram.TotalCount(), stats.TotRespSize,
+			stats.NumRequests)
+	}
+
+	r, _ = NewRawCfg([]string{"X-Fail: 1"})
+	cfg = NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false,
+		"", "", "", false, WithRaw(r))
+	stats = runSession(t, cfg, ch)
+	if stats.NumRequests != 0 || stats.NumErrs == 0 || errorCount(stats, ERR_STATUS) != stats.NumErrs {
+		t.Errorf("NumRequests = %d, NumErrs = %d, Errors = %v, want only 500s", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+}
+
+// the server closes every connection after a response, the pooled connections must be replaced transparently
+func TestRunSingleLoadSession_RawServerCloses(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	headers := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				rd := bufio.NewReader(conn)
+				var head strings.Builder
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					head.WriteString(line)
+					if line == "\r\n" {
+						break
+					}
+				}
+				select {
+				case headers <- head.String():
+				default:
+				}
+				// no Connection: close, the connection just ends after the response
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+			}()
+		}
+	}()
+
+	r, _ := NewRawCfg([]string{"x-b: 2", "X-A: 1"})
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, "http://"+l.Addr().String()+"/", "", "GET", "", nil, ch, 1000, true, false, false, false,
+		"", "", "", false, WithRaw(r))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumRequests < 2 || stats.NumErrs != 0 {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+	want := "GET / HTTP/1.1\r\nHost: " + l.Addr().String() + "\r\nx-b: 2\r\nX-A: 1\r\nUser-Agent: go-wrk\r\n\r\n"
+	if got := <-headers; got != want {
+		t.Errorf("request = %q, want %q", got, want)
+	}
+}
+
+func TestRunSingleLoadSession_RawSharedPool(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer ts.Close()
+
+	r, _ := NewRawCfg(nil)
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 2, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false,
+		"", "", "", false, WithRaw(r))
+
+	// the connection of a session that ended stays in the pool for the other session
+	if stats := runSession(t, cfg, ch); stats.NumRequests == 0 || stats.NumErrs != 0 {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+	if len(r.idle) != 1 {
+		t.Fatalf("%d idle connections after a session, want 1", len(r.idle))
+	}
+	stats := runSession(t, cfg, ch)
+	if stats.ConnsOpened != 0 {
+		t.Errorf("ConnsOpened = %d, want the connection of the first session reused", stats.ConnsOpened)
+	}
+
+	cfg.Close()
+	if len(r.idle) != 0 {
+		t.Errorf("%d idle connections after Close, want 0", len(r.idle))
+	}
+}
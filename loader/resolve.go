@@ -0,0 +1,390 @@
+package loader
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	histo "github.com/HdrHistogram/hdrhistogram-go"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	DNS_DEFAULT = ""     // the Go resolver, on every new connection
+	DNS_ONCE    = "once" // resolve every host once, for the whole test
+	DNS_CONN    = "conn" // resolve on every new connection
+	DNS_TTL     = "ttl"  // resolve again when the TTL of the records expires
+)
+
+// ResolveCfg controls where the connections are made to. Overridden hosts are never resolved, the others are
+// resolved according to the mode, optionally by a specific DNS server. When a host has several addresses, the new
+// connections are spread between them round robin.
+type ResolveCfg struct {
+	overrides map[string][]string // by host:port
+	server    string              // host:port of the DNS server, empty for the system resolver
+	mode      string
+	resolver  *net.Resolver
+
+	mu    sync.Mutex
+	cache map[string]*dnsEntry // by host
+	next  uint32
+}
+
+type dnsEntry struct {
+	addrs   []string
+	expires time.Time // zero for never
+}
+
+// DNSStats DNS lookups and how the connections were spread between the addresses
+type DNSStats struct {
+	Lookups    int
+	LookupHist *histo.Histogram
+	Addrs      map[string]int // new connections by remote address
+}
+
+// NewResolveCfg overrides are curl style host:port:addr[,addr...] entries. server is an ip[:port] of a DNS server,
+// empty for the system one. mode is one of DNS_DEFAULT, DNS_ONCE, DNS_CONN or DNS_TTL.
+func NewResolveCfg(overrides []string, server, mode string) (*ResolveCfg, error) {
+	r := &ResolveCfg{overrides: make(map[string][]string), mode: mode, cache: make(map[string]*dnsEntry)}
+	switch mode {
+	case DNS_DEFAULT, DNS_ONCE, DNS_CONN, DNS_TTL:
+	default:
+		return nil, fmt.Errorf("unknown dns mode %q, expected %v, %v or %v", mode, DNS_ONCE, DNS_CONN, DNS_TTL)
+	}
+	for _, o := range overrides {
+		// host:port:addr[,addr...], where an IPv6 addr may be in brackets
+		parts := strings.SplitN(o, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid resolve entry %q, expected host:port:addr[,addr...]", o)
+		}
+		var addrs []string
+		for _, a := range splitList(parts[2]) {
+			ip := net.ParseIP(strings.Trim(a, "[]"))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q in resolve entry %q", a, o)
+			}
+			addrs = append(addrs, ip.String())
+		}
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("invalid resolve entry %q, expected host:port:addr[,addr...]", o)
+		}
+		r.overrides[net.JoinHostPort(strings.ToLower(parts[0]), parts[1])] = addrs
+	}
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+		}
+		r.server = server
+		r.resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dnsCtx, cancel := dnsContext(ctx)
+			defer cancel()
+			var d net.Dialer
+			return d.DialContext(dnsCtx, network, r.server)
+		}}
+	} else {
+		r.resolver = net.DefaultResolver
+	}
+	return r, nil
+}
+
+// dnsContext a context with the deadline and the cancellation of ctx but none of its values, for dialing the DNS
+// server. The httptrace of a request would take that dial for the connect of the request.
+func dnsContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	var dnsCtx context.Context
+	var cancel context.CancelFunc
+	if deadline, ok := ctx.Deadline(); ok {
+		dnsCtx, cancel = context.WithDeadline(context.Background(), deadline)
+	} else {
+		dnsCtx, cancel = context.WithCancel(context.Background())
+	}
+	stop := context.AfterFunc(ctx, cancel)
+	return dnsCtx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// WithResolve sets the DNS overrides and resolver used for new connections
+func WithResolve(r *ResolveCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.resolve = r
+	}
+}
+
+func withResolve(r *ResolveCfg) clientOption {
+	return func(o *clientOpts) {
+		o.resolve = r
+	}
+}
+
+func newDNSStats(duration int) *DNSStats {
+	return &DNSStats{LookupHist: newHistogram(duration), Addrs: make(map[string]int)}
+}
+
+func (d *DNSStats) merge(o *DNSStats) {
+	d.Lookups += o.Lookups
+	d.LookupHist.Merge(o.LookupHist)
+	for k, v := range o.Addrs {
+		d.Addrs[k] += v
+	}
+}
+
+// recordDNS records the lookup of a new connection, and the address it was made to
+func (cfg *LoadCfg) recordDNS(stats *RequesterStats, res *reqResult) {
+	if res.dnsStart.IsZero() && (cfg.resolve == nil || res.remoteAddr == "") {
+		return
+	}
+	if stats.DNS == nil {
+		stats.DNS = newDNSStats(cfg.duration)
+	}
+	if !res.dnsStart.IsZero() && !res.dnsDone.IsZero() {
+		stats.DNS.Lookups++
+		stats.DNS.LookupHist.RecordValue(res.dnsDone.Sub(res.dnsStart).Microseconds())
+	}
+	if cfg.resolve != nil && res.remoteAddr != "" {
+		stats.DNS.Addrs[res.remoteAddr]++
+	}
+}
+
+// addrs the addresses of host, from the overrides, the cache or a lookup. The lookup is traced as the Go resolver
+// would trace it, so it is measured the same way.
+func (r *ResolveCfg) addrs(ctx context.Context, host, port string) ([]string, error) {
+	if addrs, ok := r.overrides[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+		return addrs, nil
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	caching := r.mode == DNS_ONCE || r.mode == DNS_TTL
+	if caching {
+		r.mu.Lock()
+		e, ok := r.cache[host]
+		r.mu.Unlock()
+		if ok && (e.expires.IsZero() || time.Now().Before(e.expires)) {
+			return e.addrs, nil
+		}
+	}
+
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.DNSStart != nil {
+		trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+	}
+	var addrs []string
+	var ttl time.Duration
+	var err error
+	if r.mode == DNS_TTL {
+		addrs, ttl, err = r.lookupTTL(ctx, host)
+	} else {
+		addrs, err = r.resolver.LookupHost(ctx, host)
+	}
+	if trace != nil && trace.DNSDone != nil {
+		trace.DNSDone(httptrace.DNSDoneInfo{Err: err})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if caching {
+		e := &dnsEntry{addrs: addrs}
+		if r.mode == DNS_TTL {
+			e.expires = time.Now().Add(ttl)
+		}
+		r.mu.Lock()
+		r.cache[host] = e
+		r.mu.Unlock()
+	}
+	return addrs, nil
+}
+
+// wrapDial a dial function that connects to the resolved addresses, starting from the next one round robin
+func (r *ResolveCfg) wrapDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || !strings.HasPrefix(network, "tcp") && !strings.HasPrefix(network, "udp") {
+			return dial(ctx, network, addr)
+		}
+		addrs, err := r.addrs(ctx, host, port)
+		if err != nil {
+			return nil, err
+		}
+		first := int(atomic.AddUint32(&r.next, 1))
+		for i := range addrs {
+			var conn net.Conn
+			if conn, err = dial(ctx, network, net.JoinHostPort(addrs[(first+i)%len(addrs)], port)); err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// sessionDial the dial function of the connections that are not made by an http.Client
+func (cfg *LoadCfg) sessionDial() dialFunc {
+	dialer := &net.Dialer{Timeout: time.Millisecond * time.Duration(cfg.timeoutms), KeepAlive: 30 * time.Second}
+	dial := baseDial(dialer, cfg.bind)
+	if cfg.resolve != nil {
+		dial = cfg.resolve.wrapDial(dial)
+	}
+	if cfg.wire != nil {
+		dial = cfg.wire.wrapDial(dial)
+	}
+	if cfg.conns != nil {
+		dial = cfg.conns.wrapDial(dial)
+	}
+	if cfg.proxy != nil {
+		// a proxy tunnels TCP connections only, the datagrams of udp:// targets are sent directly
+		proxied := cfg.proxy.wrapDial(dial)
+		direct := dial
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasPrefix(network, "tcp") {
+				return proxied(ctx, network, addr)
+			}
+			return direct(ctx, network, addr)
+		}
+	}
+	return dial
+}
+
+// dnsServer the DNS server for TTL lookups, the first nameserver of resolv.conf unless one was given
+func (r *ResolveCfg) dnsServer() string {
+	if r.server != "" {
+		return r.server
+	}
+	if data, err := os.ReadFile("/etc/resolv.conf"); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if f := strings.Fields(line); len(f) >= 2 && f[0] == "nameserver" {
+				return net.JoinHostPort(f[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+// lookupTTL resolves the A and AAAA records of host, returning the smallest TTL of the answers
+func (r *ResolveCfg) lookupTTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	name, err := dnsmessage.NewName(strings.TrimSuffix(host, ".") + ".")
+	if err != nil {
+		return nil, 0, err
+	}
+	var addrs []string
+	ttl := time.Duration(-1)
+	for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+		answers, err := r.query(ctx, name, qtype)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, a := range answers {
+			var ip net.IP
+			switch body := a.Body.(type) {
+			case *dnsmessage.AResource:
+				ip = body.A[:]
+			case *dnsmessage.AAAAResource:
+				ip = body.AAAA[:]
+			default:
+				continue
+			}
+			addrs = append(addrs, ip.String())
+			if d := time.Duration(a.Header.TTL) * time.Second; ttl < 0 || d < ttl {
+				ttl = d
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return addrs, ttl, nil
+}
+
+func (r *ResolveCfg) query(ctx context.Context, name dnsmessage.Name, qtype dnsmessage.Type) ([]dnsmessage.Resource, error) {
+	id := uint16(time.Now().UnixNano())
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := r.exchange(ctx, "udp", packed, id)
+	if err == nil && reply.Header.Truncated {
+		// the answers did not fit in the datagram, a TCP reply has them all
+		reply, err = r.exchange(ctx, "tcp", packed, id)
+		if err == nil && reply.Header.Truncated {
+			err = errors.New("dns reply truncated")
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reply.Header.RCode == dnsmessage.RCodeNameError {
+		return nil, nil
+	} else if reply.Header.RCode != dnsmessage.RCodeSuccess {
+		return nil, errors.New("dns server failure: " + reply.Header.RCode.String())
+	}
+	return reply.Answers, nil
+}
+
+// exchange sends a packed query with the given id to the DNS server over udp or tcp, and reads its reply
+func (r *ResolveCfg) exchange(ctx context.Context, network string, packed []byte, id uint16) (*dnsmessage.Message, error) {
+	dnsCtx, cancel := dnsContext(ctx)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(dnsCtx, network, r.dnsServer())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+	}
+
+	if network == "tcp" {
+		// the messages are prefixed by their length
+		if _, err = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(packed))), packed...)); err != nil {
+			return nil, err
+		}
+		var size [2]byte
+		if _, err = io.ReadFull(conn, size[:]); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err = io.ReadFull(conn, buf); err != nil {
+			return nil, err
+		}
+		var reply dnsmessage.Message
+		if err = reply.Unpack(buf); err != nil {
+			return nil, err
+		}
+		if reply.Header.ID != id {
+			return nil, errors.New("dns reply to another query")
+		}
+		return &reply, nil
+	}
+
+	if _, err = conn.Write(packed); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		var reply dnsmessage.Message
+		if err = reply.Unpack(buf[:n]); err != nil || reply.Header.ID != id {
+			continue // not the reply to this query
+		}
+		return &reply, nil
+	}
+}
@@ -0,0 +1,274 @@
+package loader
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newDNSTestServer answers every A query with 127.0.0.1 and the given TTL, and AAAA queries with nothing
+func newDNSTestServer(t *testing.T, ttl uint32) string {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var q dnsmessage.Message
+			if q.Unpack(buf[:n]) != nil || len(q.Questions) != 1 {
+				continue
+			}
+			reply := dnsmessage.Message{Header: dnsmessage.Header{ID: q.Header.ID, Response: true}, Questions: q.Questions}
+			if q.Questions[0].Type == dnsmessage.TypeA {
+				reply.Answers = []dnsmessage.Resource{{
+					Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: ttl},
+					Body:   &dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				}}
+			}
+			packed, _ := reply.Pack()
+			_, _ = c.WriteTo(packed, addr)
+		}
+	}()
+	return c.LocalAddr().String()
+}
+
+func newOkServer(t *testing.T) (*httptest.Server, string) {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Host))
+	}))
+	t.Cleanup(ts.Close)
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	return ts, port
+}
+
+func TestNewResolveCfg(t *testing.T) {
+	r, err := NewResolveCfg([]string{"API.example:443:10.0.0.1,[::1]"}, "10.0.0.53", DNS_ONCE)
+	if err != nil {
+		t.Fatalf("NewResolveCfg err = %v", err)
+	}
+	if got := r.overrides["api.example:443"]; len(got) != 2 || got[0] != "10.0.0.1" || got[1] != "::1" {
+		t.Errorf("overrides = %v", r.overrides)
+	}
+	if r.server != "10.0.0.53:53" {
+		t.Errorf("server = %q, want the default port", r.server)
+	}
+	for _, bad := range []string{"api.example:443", "api.example::10.0.0.1", "api.example:443:nope", "api.example:443:"} {
+		if _, err = NewResolveCfg([]string{bad}, "", ""); err == nil {
+			t.Errorf("NewResolveCfg(%q) want err, got nil", bad)
+		}
+	}
+	if _, err = NewResolveCfg(nil, "", "always"); err == nil {
+		t.Error("want err for an unknown mode, got nil")
+	}
+}
+
+func TestRunSingleLoadSession_ResolveOverride(t *testing.T) {
+	ts, port := newOkServer(t)
+	ts.Listener.Close()
+	// listen on all the loopback addresses, so the connections can be spread between two of them
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		t.Skipf("can't listen on all the addresses: %v", err)
+	}
+	srv := &http.Server{Handler: ts.Config.Handler}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+
+	r, _ := NewResolveCfg([]string{"prod.example:" + port + ":127.0.0.1,127.0.0.2"}, "", "")
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, "http://prod.example:"+port+"/", "", "GET", "", nil, ch, 1000, true, false, true, false,
+		"", "", "", false, WithResolve(r))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumRequests == 0 || stats.NumErrs != 0 {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+	if stats.DNS == nil || stats.DNS.Lookups != 0 {
+		t.Fatalf("DNS = %+v, want no lookups for an overridden host", stats.DNS)
+	}
+	a, b := stats.DNS.Addrs["127.0.0.1"], stats.DNS.Addrs["127.0.0.2"]
+	if a == 0 || b == 0 || a+b != stats.NumRequests || a-b > 1 || b-a > 1 {
+		t.Errorf("Addrs = %v, want the %d connections spread evenly", stats.DNS.Addrs, stats.NumRequests)
+	}
+}
+
+func TestRunSingleLoadSession_ResolveModes(t *testing.T) {
+	_, port := newOkServer(t)
+	cases := []struct {
+		mode string
+		ttl  uint32
+		want func(lookups, conns int) bool
+	}{
+		{DNS_ONCE, 0, func(lookups, conns int) bool { return lookups == 1 }},
+		{DNS_CONN, 3600, func(lookups, conns int) bool { return lookups == conns }},
+		{DNS_TTL, 3600, func(lookups, conns int) bool { return lookups == 1 }},
+		{DNS_TTL, 0, func(lookups, conns int) bool { return lookups == conns }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.mode, func(t *testing.T) {
+			r, err := NewResolveCfg(nil, newDNSTestServer(t, tc.ttl), tc.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ch := make(chan *RequesterStats, 1)
+			// a connection per request
+			cfg := NewLoadCfg(1, 1, "http://service.test:"+port+"/", "", "GET", "", nil, ch, 1000, true, false, true, false,
+				"", "", "", false, WithResolve(r))
+
+			stats := runSession(t, cfg, ch)
+
+			if stats.NumRequests < 2 || stats.NumErrs != 0 {
+				t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+			}
+			if !tc.want(stats.DNS.Lookups, stats.ConnsOpened) {
+				t.Errorf("ttl %d: %d lookups for %d connections", tc.ttl, stats.DNS.Lookups, stats.ConnsOpened)
+			}
+			if stats.DNS.LookupHist.TotalCount() != int64(stats.DNS.Lookups) {
+				t.Errorf("LookupHist count = %d, want %d", stats.DNS.LookupHist.TotalCount(), stats.DNS.Lookups)
+			}
+		})
+	}
+}
+
+func TestResolveCfg_UntracedDNSDial(t *testing.T) {
+	for _, mode := range []string{DNS_CONN, DNS_TTL} {
+		r, err := NewResolveCfg(nil, newDNSTestServer(t, 0), mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the dial to the DNS server is not the connect of the request
+		var connects atomic.Int32
+		ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+			ConnectStart: func(network, addr string) { connects.Add(1) },
+			ConnectDone:  func(network, addr string, err error) { connects.Add(1) },
+		})
+		ctx, cancel := context.WithTimeout(ctx, time.Second)
+		addrs, err := r.addrs(ctx, "service.test", "80")
+		cancel()
+		if err != nil || len(addrs) != 1 || addrs[0] != "127.0.0.1" {
+			t.Fatalf("%v: addrs = %v, err = %v", mode, addrs, err)
+		}
+		if n := connects.Load(); n != 0 {
+			t.Errorf("%v: the lookup called the connect hooks %d times", mode, n)
+		}
+	}
+}
+
+func TestRunSingleLoadSession_ResolveFails(t *testing.T) {
+	r, _ := NewResolveCfg(nil, "127.0.0.1:1", DNS_TTL)
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, "http://service.test/", "", "GET", "", nil, ch, 200, true, false, true, false,
+		"", "", "", false, WithResolve(r))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumRequests != 0 || stats.NumErrs == 0 {
+		t.Errorf("NumRequests = %d, NumErrs = %d, want only errors", stats.NumRequests, stats.NumErrs)
+	}
+	for e := range stats.Errors {
+		if e != ERR_REFUSED && e != ERR_CONNECT_TIMEOUT && e != ERR_HEADER_TIMEOUT {
+			t.Errorf("unexpected error %q: %v", e, stats.Errors[e])
+		}
+	}
+}
+
+// newTruncatingDNSTestServer answers A queries with a single record and the TC bit over UDP, and with all of addrs
+// over TCP on the same port, still truncated when tcpTruncated is set
+func newTruncatingDNSTestServer(t *testing.T, addrs [][4]byte, tcpTruncated bool) string {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	l, err := net.Listen("tcp", c.LocalAddr().String())
+	if err != nil {
+		t.Skipf("the TCP port of the DNS server is taken: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	answer := func(query []byte, addrs [][4]byte, truncated bool) []byte {
+		var q dnsmessage.Message
+		if q.Unpack(query) != nil || len(q.Questions) != 1 {
+			return nil
+		}
+		reply := dnsmessage.Message{Header: dnsmessage.Header{ID: q.Header.ID, Response: true}, Questions: q.Questions}
+		if q.Questions[0].Type == dnsmessage.TypeA {
+			reply.Header.Truncated = truncated
+			for _, a := range addrs {
+				reply.Answers = append(reply.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   &dnsmessage.AResource{A: a},
+				})
+			}
+		}
+		packed, _ := reply.Pack()
+		return packed
+	}
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = c.WriteTo(answer(buf[:n], addrs[:1], true), addr)
+		}
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			var size [2]byte
+			if _, err = io.ReadFull(conn, size[:]); err == nil {
+				query := make([]byte, binary.BigEndian.Uint16(size[:]))
+				if _, err = io.ReadFull(conn, query); err == nil {
+					reply := answer(query, addrs, tcpTruncated)
+					_, _ = conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(reply))), reply...))
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return c.LocalAddr().String()
+}
+
+func TestResolveCfg_LookupTruncated(t *testing.T) {
+	addrs := [][4]byte{{10, 0, 0, 1}, {10, 0, 0, 2}, {10, 0, 0, 3}}
+	r, err := NewResolveCfg(nil, newTruncatingDNSTestServer(t, addrs, false), DNS_TTL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// the truncated UDP reply is retried over TCP
+	got, ttl, err := r.lookupTTL(ctx, "service.test")
+	if err != nil || len(got) != len(addrs) || ttl != time.Minute {
+		t.Fatalf("lookupTTL = %v, %v, %v, want the %d addresses of the TCP reply", got, ttl, err, len(addrs))
+	}
+
+	r, _ = NewResolveCfg(nil, newTruncatingDNSTestServer(t, addrs, true), DNS_TTL)
+	if got, _, err = r.lookupTTL(ctx, "service.test"); err == nil {
+		t.Errorf("lookupTTL = %v, want an error for a truncated TCP reply", got)
+	}
+}
@@ -0,0 +1,225 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	histo "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+// largest UDP datagram, so a read never truncates a reply
+const maxDatagram = 64 * 1024
+
+// SocketCfg a raw TCP or UDP workload. Every request writes the payload and reads a reply that is either a fixed
+// number of bytes, or ends with a delimiter. Without either, whatever arrives in a single read (a datagram on UDP)
+// is the reply.
+type SocketCfg struct {
+	payload *template.Template
+	static  []byte // the payload, when it does not depend on the sequence number
+	respLen int
+	delim   []byte
+	seq     int64
+}
+
+// socketPayloadVars the data available to the payload template
+type socketPayloadVars struct {
+	Seq int64 // sequence number of the request, unique across all goroutines
+}
+
+// SocketStats raw socket specific statistics. The round trips are the requests of the common statistics.
+type SocketStats struct {
+	Connects    int
+	ConnectErrs int
+	BytesSent   int64
+	ConnectHist *histo.Histogram
+}
+
+// NewSocketCfg payload is a hex string, optionally a text/template with .Seq (e.g. {{printf "%08x" .Seq}}).
+// Whitespace in the payload and the delimiter is ignored. respLen and delim may be 0 and empty.
+func NewSocketCfg(payload string, respLen int, delim string) (*SocketCfg, error) {
+	if respLen < 0 {
+		return nil, errors.New("socket response length can't be negative")
+	}
+	s := &SocketCfg{respLen: respLen}
+	var err error
+	if s.delim, err = decodeHex(delim); err != nil {
+		return nil, fmt.Errorf("invalid socket delimiter: %v", err)
+	}
+	if respLen > 0 && len(s.delim) > 0 {
+		return nil, errors.New("socket response length and delimiter are mutually exclusive")
+	}
+	if strings.Contains(payload, "{{") {
+		if s.payload, err = template.New("payload").Parse(payload); err != nil {
+			return nil, fmt.Errorf("invalid socket payload template: %v", err)
+		}
+		// render once, so a payload that is not hex fails now rather than on every request
+		if _, err = s.render(0); err != nil {
+			return nil, err
+		}
+	} else if s.static, err = decodeHex(payload); err != nil {
+		return nil, fmt.Errorf("invalid socket payload: %v", err)
+	}
+	return s, nil
+}
+
+// WithSocket sets the workload used for tcp:// and udp:// urls
+func WithSocket(s *SocketCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.socket = s
+	}
+}
+
+// IsSocketUrl true for tcp:// and udp:// urls
+func IsSocketUrl(u string) bool {
+	return strings.HasPrefix(u, "tcp://") || strings.HasPrefix(u, "udp://")
+}
+
+func decodeHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.Join(strings.Fields(s), ""))
+}
+
+func newSocketStats(duration int) *SocketStats {
+	return &SocketStats{ConnectHist: newHistogram(duration)}
+}
+
+func (s *SocketStats) merge(o *SocketStats) {
+	s.Connects += o.Connects
+	s.ConnectErrs += o.ConnectErrs
+	s.BytesSent += o.BytesSent
+	s.ConnectHist.Merge(o.ConnectHist)
+}
+
+func (s *SocketCfg) render(seq int64) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.payload.Execute(&buf, socketPayloadVars{Seq: seq}); err != nil {
+		return nil, err
+	}
+	payload, err := decodeHex(buf.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid socket payload: %v", err)
+	}
+	return payload, nil
+}
+
+// next the payload of the next request
+func (s *SocketCfg) next() ([]byte, error) {
+	if s.payload == nil {
+		return s.static, nil
+	}
+	return s.render(atomic.AddInt64(&s.seq, 1))
+}
+
+// readReply reads a single reply. Bytes after a delimiter stay buffered for the next reply.
+func (s *SocketCfg) readReply(rd *bufio.Reader) (int, error) {
+	switch {
+	case s.respLen > 0:
+		n, err := io.CopyN(io.Discard, rd, int64(s.respLen))
+		return int(n), err
+	case len(s.delim) > 0:
+		last := s.delim[len(s.delim)-1]
+		var window []byte // the tail of the reply, to match a delimiter split between reads
+		n := 0
+		for {
+			chunk, err := rd.ReadSlice(last)
+			n += len(chunk)
+			if err == bufio.ErrBufferFull {
+				window = append(window[:0], chunk[len(chunk)-len(s.delim)+1:]...)
+				continue
+			} else if err != nil {
+				return n, err
+			}
+			window = append(window, chunk...)
+			if bytes.HasSuffix(window, s.delim) {
+				return n, nil
+			}
+			if len(window) >= len(s.delim) {
+				window = window[len(window)-len(s.delim)+1:]
+			}
+		}
+	default:
+		if _, err := rd.Peek(1); err != nil {
+			return 0, err
+		}
+		n, err := rd.Discard(rd.Buffered())
+		return n, err
+	}
+}
+
+// runSocketSession the RunSingleLoadSession loop for tcp:// and udp:// targets
+func (cfg *LoadCfg) runSocketSession(stats *RequesterStats, start time.Time) {
+	stats.Socket = newSocketStats(cfg.duration)
+	u, err := url.Parse(cfg.testUrl)
+	if err != nil {
+		stats.recordError(err)
+		return
+	}
+	timeout := time.Millisecond * time.Duration(cfg.timeoutms)
+
+	var conn net.Conn
+	var rd *bufio.Reader
+	closeConn := func() {
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+	}
+	defer closeConn()
+
+	for !cfg.done(start) {
+		stats.tick()
+		if conn == nil {
+			connStart := time.Now()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			conn, err = cfg.sessionDial()(ctx, u.Scheme, u.Host)
+			cancel()
+			if err != nil {
+				stats.recordError(err)
+				stats.Socket.ConnectErrs++
+				continue
+			}
+			stats.Socket.Connects++
+			stats.Socket.ConnectHist.RecordValue(time.Since(connStart).Microseconds())
+			rd = bufio.NewReaderSize(conn, maxDatagram)
+		}
+
+		payload, err := cfg.socket.next()
+		if err != nil {
+			stats.recordError(err)
+			continue
+		}
+		reqStart := time.Now()
+		conn.SetDeadline(reqStart.Add(timeout))
+		sent, err := conn.Write(payload)
+		stats.Socket.BytesSent += int64(sent)
+		stats.TotReqSize += int64(sent)
+		var respSize int
+		if err == nil {
+			respSize, err = cfg.socket.readReply(rd)
+		}
+		reqDur := time.Since(reqStart)
+		if err != nil {
+			stats.recordError(err)
+			// the connection is out of step with the replies
+			closeConn()
+			continue
+		}
+		stats.TotRespSize += int64(respSize)
+		stats.TotDuration += reqDur
+		stats.recordLatency(reqDur)
+		stats.NumRequests++
+		if cfg.disableKeepAlive {
+			closeConn()
+		}
+	}
+}
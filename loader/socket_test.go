@@ -0,0 +1,149 @@
+package loader
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+)
+
+// newTCPTestServer answers every line with "ok <line>\n"
+func newTCPTestServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				rd := bufio.NewReader(c)
+				for {
+					line, err := rd.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if _, err = c.Write([]byte("ok " + line)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return "tcp://" + l.Addr().String()
+}
+
+// newUDPTestServer echoes every datagram
+func newUDPTestServer(t *testing.T) string {
+	t.Helper()
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	go func() {
+		buf := make([]byte, maxDatagram)
+		for {
+			n, addr, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = c.WriteTo(buf[:n], addr)
+		}
+	}()
+	return "udp://" + c.LocalAddr().String()
+}
+
+func TestNewSocketCfg(t *testing.T) {
+	s, err := NewSocketCfg("de ad\nbe ef", 0, "0d0a")
+	if err != nil {
+		t.Fatalf("NewSocketCfg err = %v", err)
+	}
+	if p, _ := s.next(); string(p) != "\xde\xad\xbe\xef" || string(s.delim) != "\r\n" {
+		t.Errorf("payload = %x, delim = %x", p, s.delim)
+	}
+
+	s, err = NewSocketCfg(`01{{printf "%02x" .Seq}}`, 0, "")
+	if err != nil {
+		t.Fatalf("NewSocketCfg err = %v", err)
+	}
+	if p, _ := s.next(); string(p) != "\x01\x01" {
+		t.Errorf("first payload = %x, want 0101", p)
+	}
+	if p, _ := s.next(); string(p) != "\x01\x02" {
+		t.Errorf("second payload = %x, want 0102", p)
+	}
+
+	for _, bad := range []struct {
+		payload string
+		respLen int
+		delim   string
+	}{{"zz", 0, ""}, {"00", 0, "0"}, {"00", -1, ""}, {"00", 4, "0a"}, {"{{.Nope}}", 0, ""}, {"{{.Seq}}", 0, ""}} {
+		if _, err = NewSocketCfg(bad.payload, bad.respLen, bad.delim); err == nil {
+			t.Errorf("NewSocketCfg(%q, %d, %q) want err, got nil", bad.payload, bad.respLen, bad.delim)
+		}
+	}
+}
+
+func TestSocketCfg_ReadReply(t *testing.T) {
+	s, _ := NewSocketCfg("", 0, "0d0a")
+	// the delimiter is split between two reads of the minimal buffer
+	rd := bufio.NewReaderSize(strings.NewReader(strings.Repeat("x", 15)+"\r\nnext\r\n"), 16)
+	if n, err := s.readReply(rd); n != 17 || err != nil {
+		t.Errorf("readReply = %d, %v, want 17, nil", n, err)
+	}
+	if n, err := s.readReply(rd); n != 6 || err != nil {
+		t.Errorf("second readReply = %d, %v, want 6, nil", n, err)
+	}
+
+	s, _ = NewSocketCfg("", 3, "")
+	rd = bufio.NewReader(strings.NewReader("abcd"))
+	if n, err := s.readReply(rd); n != 3 || err != nil {
+		t.Errorf("fixed length readReply = %d, %v, want 3, nil", n, err)
+	}
+	if _, err := s.readReply(rd); err == nil {
+		t.Error("want err for a short reply, got nil")
+	}
+}
+
+func TestRunSingleLoadSession_Socket(t *testing.T) {
+	cases := []struct {
+		name      string
+		url       string
+		delim     string
+		keepAlive bool
+	}{
+		{"tcp", newTCPTestServer(t), "0a", true},
+		{"tcp_no_ka", newTCPTestServer(t), "0a", false},
+		{"udp", newUDPTestServer(t), "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := NewSocketCfg("70696e670a", 0, tc.delim) // "ping\n"
+			ch := make(chan *RequesterStats, 1)
+			cfg := NewLoadCfg(1, 1, tc.url, "", "GET", "", nil, ch, 1000, true, false, !tc.keepAlive, false, "", "", "", false, WithSocket(s))
+
+			stats := runSession(t, cfg, ch)
+
+			if stats.NumRequests == 0 || stats.NumErrs != 0 {
+				t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+			}
+			if stats.Socket.BytesSent != int64(5*stats.NumRequests) {
+				t.Errorf("BytesSent = %d, want %d", stats.Socket.BytesSent, 5*stats.NumRequests)
+			}
+			wantConnects := 1
+			if !tc.keepAlive {
+				wantConnects = stats.NumRequests
+			}
+			if stats.Socket.Connects != wantConnects || int(stats.Socket.ConnectHist.TotalCount()) != wantConnects {
+				t.Errorf("Connects = %d, want %d", stats.Socket.Connects, wantConnects)
+			}
+		})
+	}
+}
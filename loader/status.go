@@ -0,0 +1,101 @@
+package loader
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DEFAULT_SUCCESS the status codes counted as successful unless WithSuccess says otherwise
+const DEFAULT_SUCCESS = "2xx,3xx"
+
+// SuccessCfg the response status codes counted as successful, the others are errors
+type SuccessCfg struct {
+	ranges [][2]int // inclusive
+}
+
+// NewSuccessCfg spec is a comma separated list of status codes (304), classes (2xx) and ranges (200-299)
+func NewSuccessCfg(spec string) (*SuccessCfg, error) {
+	s := &SuccessCfg{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		var lo, hi int
+		var err error
+		if len(item) == 3 && strings.EqualFold(item[1:], "xx") {
+			lo, err = strconv.Atoi(item[:1])
+			lo, hi = lo*100, lo*100+99
+		} else if from, to, ok := strings.Cut(item, "-"); ok {
+			if lo, err = strconv.Atoi(from); err == nil {
+				hi, err = strconv.Atoi(to)
+			}
+		} else {
+			lo, err = strconv.Atoi(item)
+			hi = lo
+		}
+		if err != nil || lo < 100 || hi > 599 || lo > hi {
+			return nil, fmt.Errorf("invalid success status %q, expected a code (304), a class (2xx) or a range (200-299)", item)
+		}
+		s.ranges = append(s.ranges, [2]int{lo, hi})
+	}
+	if len(s.ranges) == 0 {
+		return nil, fmt.Errorf("no success status codes in %q", spec)
+	}
+	return s, nil
+}
+
+// WithSuccess sets the status codes counted as successful, DEFAULT_SUCCESS by default
+func WithSuccess(s *SuccessCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.success = s
+	}
+}
+
+// ok whether a status code is successful
+func (s *SuccessCfg) ok(code int) bool {
+	if s == nil {
+		return code >= 200 && code < 400
+	}
+	for _, r := range s.ranges {
+		if code >= r[0] && code <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusError a response whose status code is not counted as successful
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprint("received status code ", e.Code)
+}
+
+// recordStatus counts a response by its status code, and records the latency of one that is not successful
+func (stats *RequesterStats) recordStatus(code int, ok bool, reqDur time.Duration) {
+	stats.StatusCodes[code]++
+	if ok {
+		return
+	}
+	if stats.FailedHistogram == nil {
+		stats.FailedHistogram = emptyLike(stats.Histogram)
+	}
+	stats.FailedHistogram.RecordValue(reqDur.Microseconds())
+}
+
+// failedResponse true for the errors of a response that was received in full but is not successful: by its status,
+// an assertion or the GraphQL or gRPC errors it carries
+func failedResponse(err error) bool {
+	var statusErr *StatusError
+	var assertErr *AssertionError
+	var graphqlErr *GraphQLError
+	var grpcErr *GRPCError
+	return errors.As(err, &statusErr) || errors.As(err, &assertErr) || errors.As(err, &graphqlErr) ||
+		errors.As(err, &grpcErr)
+}
@@ -0,0 +1,149 @@
+package loader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewSuccessCfg(t *testing.T) {
+	for _, tc := range []struct {
+		spec string
+		ok   []int
+		fail []int
+	}{
+		{"2xx", []int{200, 204, 299}, []int{199, 300, 304, 500}},
+		{"2xx,304", []int{200, 304}, []int{301, 302, 404}},
+		{"200-204, 4XX", []int{200, 204, 404, 499}, []int{205, 302, 500}},
+		{DEFAULT_SUCCESS, []int{200, 204, 302, 304, 308}, []int{404, 503}},
+	} {
+		s, err := NewSuccessCfg(tc.spec)
+		if err != nil {
+			t.Fatalf("NewSuccessCfg(%q) err = %v", tc.spec, err)
+		}
+		for _, code := range tc.ok {
+			if !s.ok(code) {
+				t.Errorf("%q: %d is not successful", tc.spec, code)
+			}
+		}
+		for _, code := range tc.fail {
+			if s.ok(code) {
+				t.Errorf("%q: %d is successful", tc.spec, code)
+			}
+		}
+	}
+	for _, spec := range []string{"", "2x", "abc", "600", "299-200", "9xx", "200-"} {
+		if _, err := NewSuccessCfg(spec); err == nil {
+			t.Errorf("NewSuccessCfg(%q) err = nil, want an error", spec)
+		}
+	}
+	var s *SuccessCfg
+	if !s.ok(302) || !s.ok(204) || s.ok(404) {
+		t.Errorf("the default is not %v", DEFAULT_SUCCESS)
+	}
+}
+
+func TestDoRequest_StatusError(t *testing.T) {
+	ts := newTestServer(t, func(w http.ResponseWriter, r *http.Request) {
+		code, _ := strconv.Atoi(r.URL.Query().Get("code"))
+		w.WriteHeader(code)
+	})
+
+	for _, code := range []int{http.StatusNoContent, http.StatusFound, http.StatusNotModified, http.StatusPermanentRedirect} {
+		if _, dur, err := DoRequest(keepLastResponseClient(), nil, "GET", "", ts.URL+"?code="+strconv.Itoa(code), ""); err != nil || dur <= 0 {
+			t.Errorf("%d: dur = %v, err = %v, want a success", code, dur, err)
+		}
+	}
+
+	respSize, dur, err := DoRequest(defaultTestClient(t), nil, "GET", "", ts.URL+"?code=503", "")
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("err = %v, want a *StatusError for 503", err)
+	}
+	if respSize <= 0 || dur <= 0 {
+		t.Errorf("respSize = %d, dur = %v, want the size and duration of the response", respSize, dur)
+	}
+}
+
+func TestRunSingleLoadSession_StatusCodes(t *testing.T) {
+	var n atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch n.Add(1) % 3 {
+		case 0:
+			w.WriteHeader(http.StatusNoContent)
+		case 1:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			_, _ = w.Write([]byte("ok"))
+		}
+	}))
+	defer ts.Close()
+
+	for _, spec := range []string{DEFAULT_SUCCESS, "2xx,404"} {
+		success, err := NewSuccessCfg(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Store(0)
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false,
+			WithSuccess(success))
+		stats := runSession(t, cfg, ch)
+
+		codes := stats.StatusCodes
+		if codes[http.StatusOK] == 0 || codes[http.StatusNoContent] == 0 || codes[http.StatusNotFound] == 0 {
+			t.Fatalf("%v: StatusCodes = %v, want 200, 204 and 404", spec, codes)
+		}
+		if spec == DEFAULT_SUCCESS {
+			// the 204s are successful even though they have no body
+			if stats.NumRequests != codes[http.StatusOK]+codes[http.StatusNoContent] || stats.NumErrs != codes[http.StatusNotFound] ||
+				errorCount(stats, ERR_STATUS) != stats.NumErrs {
+				t.Errorf("%v: NumRequests = %d, NumErrs = %d, Errors = %v, StatusCodes = %v", spec, stats.NumRequests,
+					stats.NumErrs, stats.Errors, codes)
+			}
+			if stats.FailedHistogram == nil || stats.FailedHistogram.TotalCount() != int64(codes[http.StatusNotFound]) {
+				t.Errorf("%v: FailedHistogram = %v, want the latency of the 404s", spec, stats.FailedHistogram)
+			}
+		} else if stats.NumErrs != 0 || stats.FailedHistogram != nil {
+			t.Errorf("%v: NumErrs = %d, Errors = %v, want the 404s to be successful", spec, stats.NumErrs, stats.Errors)
+		}
+	}
+}
+
+func TestRunSingleLoadSession_SuccessModes(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte(`{"data":{"ok":true}}`))
+	}))
+	defer ts.Close()
+
+	g, _ := NewGraphQLCfg("query Ok { ok }", "", "")
+	rpc, _ := NewGRPCCfg("test.Svc/Call", [][]byte{[]byte("a")})
+	sse, _ := NewStreamCfg(STREAM_SSE, "")
+	poll, _ := NewStreamCfg(STREAM_LONGPOLL, "")
+	for _, tc := range []struct {
+		name   string
+		url    string
+		option Option
+	}{
+		{"graphql", ts.URL, WithGraphQL(g)},
+		{"grpc", newGRPCTestServer(t), WithGRPC(rpc)},
+		{"sse", ts.URL, WithStream(sse)},
+		{"longpoll", ts.URL, WithStream(poll)},
+	} {
+		// every response is a 200
+		success, _ := NewSuccessCfg("201")
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, tc.url, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false,
+			tc.option, WithSuccess(success))
+		stats := runSession(t, cfg, ch)
+
+		if stats.NumRequests != 0 || stats.NumErrs == 0 || errorCount(stats, ERR_STATUS) != stats.NumErrs {
+			t.Errorf("%v: NumRequests = %d, NumErrs = %d, Errors = %v, want only status errors", tc.name,
+				stats.NumRequests, stats.NumErrs, stats.Errors)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+package loader
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	histo "github.com/HdrHistogram/hdrhistogram-go"
+)
+
+const (
+	STREAM_SSE      = "sse"      // Server-Sent Events, a long lived text/event-stream response per goroutine
+	STREAM_LONGPOLL = "longpoll" // repeated requests that are answered when a notification is available
+
+	SSE_RETRY     = 3 * time.Second // SSE reconnection delay until the server sends a retry: field, as in browsers
+	SSE_MAX_RETRY = time.Minute     // the longest delay, doubled from the retry after every failed connection in a row
+)
+
+// StreamCfg a streaming workload. tsField optionally names a JSON field of the event data (or long-poll body) that
+// holds the time the notification was published, as unix milliseconds or an RFC 3339 string.
+type StreamCfg struct {
+	mode    string
+	tsField string
+}
+
+// StreamStats streaming specific statistics. The events are the requests of the common statistics.
+type StreamStats struct {
+	Connects       int // SSE connections or long-poll requests
+	Reconnects     int // SSE connections after the first one
+	Events         int
+	EmptyPolls     int              // long-poll requests that ended without a notification
+	ConnTime       time.Duration    // total time the SSE connections were open
+	FirstEventHist *histo.Histogram // time from sending the request to the first event of a connection
+	GapHist        *histo.Histogram // time between consecutive events of a connection
+	DeliveryHist   *histo.Histogram // time from publishing to receiving, when the events carry a timestamp
+}
+
+// sseEvent a dispatched Server-Sent Event
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+	retry time.Duration
+}
+
+// sseReader parses a text/event-stream
+type sseReader struct {
+	r *bufio.Reader
+}
+
+// NewStreamCfg validates the streaming mode, one of STREAM_SSE or STREAM_LONGPOLL. tsField may be empty.
+func NewStreamCfg(mode, tsField string) (*StreamCfg, error) {
+	if mode != STREAM_SSE && mode != STREAM_LONGPOLL {
+		return nil, fmt.Errorf("unknown stream mode %q, expected %v or %v", mode, STREAM_SSE, STREAM_LONGPOLL)
+	}
+	return &StreamCfg{mode: mode, tsField: tsField}, nil
+}
+
+// WithStream reads the responses as streams of events instead of single responses
+func WithStream(s *StreamCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.stream = s
+	}
+}
+
+func withStreaming() clientOption {
+	return func(o *clientOpts) {
+		o.streaming = true
+	}
+}
+
+func newStreamStats(duration int) *StreamStats {
+	return &StreamStats{FirstEventHist: newHistogram(duration), GapHist: newHistogram(duration), DeliveryHist: newHistogram(duration)}
+}
+
+func (s *StreamStats) merge(o *StreamStats) {
+	s.Connects += o.Connects
+	s.Reconnects += o.Reconnects
+	s.Events += o.Events
+	s.EmptyPolls += o.EmptyPolls
+	s.ConnTime += o.ConnTime
+	s.FirstEventHist.Merge(o.FirstEventHist)
+	s.GapHist.Merge(o.GapHist)
+	s.DeliveryHist.Merge(o.DeliveryHist)
+}
+
+// next reads the stream until the next event is dispatched. Comments and events without data are skipped.
+func (s *sseReader) next() (*sseEvent, error) {
+	ev := &sseEvent{}
+	var data []string
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			// an incomplete event at the end of the stream is discarded
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			if len(data) == 0 {
+				continue
+			}
+			ev.data = strings.Join(data, "\n")
+			return ev, nil
+		}
+		if line[0] == ':' {
+			continue
+		}
+		field, value := line, ""
+		if i := strings.Index(line, ":"); i != -1 {
+			field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		switch field {
+		case "data":
+			data = append(data, value)
+		case "id":
+			ev.id = value
+		case "event":
+			ev.event = value
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil {
+				ev.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// deliveryDelay the time since the notification in data was published, false when it is unknown
+func (s *StreamCfg) deliveryDelay(data []byte, now time.Time) (time.Duration, bool) {
+	if s.tsField == "" {
+		return 0, false
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return 0, false
+	}
+	var published time.Time
+	switch ts := fields[s.tsField].(type) {
+	case float64:
+		published = time.UnixMilli(int64(ts))
+	case string:
+		var err error
+		if published, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+			return 0, false
+		}
+	default:
+		return 0, false
+	}
+	if now.Before(published) { // clock skew
+		return 0, true
+	}
+	return now.Sub(published), true
+}
+
+// sessionContext a context that is cancelled at the end of the test, or when the test is stopped
+func (cfg *LoadCfg) sessionContext(start time.Time) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithDeadline(context.Background(), start.Add(time.Duration(cfg.duration)*time.Second))
+	go func() {
+		ticker := time.NewTicker(50 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if atomic.LoadInt32(&cfg.interrupted) != 0 {
+					cancel()
+				}
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+func (cfg *LoadCfg) newStreamRequest(ctx context.Context, lastEventId string) (*http.Request, error) {
+	var body io.Reader
+	if len(cfg.reqBody) > 0 {
+		body = strings.NewReader(cfg.reqBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, cfg.method, escapeUrlStr(cfg.testUrl), body)
+	if err != nil {
+		return nil, err
+	}
+	for hk, hv := range cfg.header {
+		req.Header.Add(hk, hv)
+	}
+	req.Header.Add("User-Agent", USER_AGENT)
+	if cfg.stream.mode == STREAM_SSE {
+		req.Header.Set("Accept", "text/event-stream")
+		req.Header.Set("Cache-Control", "no-cache")
+	}
+	if lastEventId != "" {
+		req.Header.Set("Last-Event-ID", lastEventId)
+	}
+	if cfg.host != "" {
+		req.Host = cfg.host
+	}
+	return req, nil
+}
+
+// runStreamSession the RunSingleLoadSession loop for streaming targets
+func (cfg *LoadCfg) runStreamSession(httpClient *http.Client, stats *RequesterStats, start time.Time) {
+	stats.Stream = newStreamStats(cfg.duration)
+	ctx, cancel := cfg.sessionContext(start)
+	defer cancel()
+
+	if cfg.stream.mode == STREAM_SSE {
+		cfg.runSSE(ctx, httpClient, stats)
+	} else {
+		cfg.runLongPoll(ctx, httpClient, stats)
+	}
+	// events are not requested one after the other, so the rates are over the whole session
+	stats.TotDuration = time.Since(start)
+}
+
+func (cfg *LoadCfg) runSSE(ctx context.Context, httpClient *http.Client, stats *RequesterStats) {
+	var lastEventId string
+	retry := SSE_RETRY
+	failures := 0
+	for first := true; ctx.Err() == nil; first = false {
+		stats.tick()
+		if !first {
+			stats.Stream.Reconnects++
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(sseDelay(retry, failures)):
+			}
+		}
+		err := cfg.sseConnection(ctx, httpClient, stats, &lastEventId, &retry)
+		if err != nil && ctx.Err() == nil {
+			stats.recordError(err)
+			failures++
+		} else {
+			failures = 0
+		}
+	}
+}
+
+// sseDelay the wait before a reconnection: the retry delay, doubled for every failed connection in a row, so an
+// endpoint that refuses the connections or answers with errors is not flooded
+func sseDelay(retry time.Duration, failures int) time.Duration {
+	for ; failures > 1 && retry < SSE_MAX_RETRY; failures-- {
+		retry *= 2
+	}
+	return min(retry, SSE_MAX_RETRY)
+}
+
+// sseConnection reads a single event stream until it ends. A stream closed by the server is not an error,
+// the caller reconnects with the last event id, as a browser would.
+func (cfg *LoadCfg) sseConnection(ctx context.Context, httpClient *http.Client, stats *RequesterStats, lastEventId *string, retry *time.Duration) error {
+	req, err := cfg.newStreamRequest(ctx, *lastEventId)
+	if err != nil {
+		return err
+	}
+	reqStart := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !cfg.success.ok(resp.StatusCode) {
+		return &StatusError{Code: resp.StatusCode}
+	}
+	stats.Stream.Connects++
+	defer func() {
+		stats.Stream.ConnTime += time.Since(reqStart)
+	}()
+
+	rd := &sseReader{r: bufio.NewReader(resp.Body)}
+	prev := reqStart
+	for {
+		ev, err := rd.next()
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return &bodyError{err}
+		}
+		now := time.Now()
+		if ev.id != "" {
+			*lastEventId = ev.id
+		}
+		if ev.retry > 0 {
+			*retry = ev.retry
+		}
+		if prev == reqStart {
+			stats.Stream.FirstEventHist.RecordValue(now.Sub(reqStart).Microseconds())
+		} else {
+			stats.Stream.GapHist.RecordValue(now.Sub(prev).Microseconds())
+		}
+		cfg.recordEvent(stats, []byte(ev.data), now.Sub(prev), now)
+		prev = now
+	}
+}
+
+func (cfg *LoadCfg) runLongPoll(ctx context.Context, httpClient *http.Client, stats *RequesterStats) {
+	for ctx.Err() == nil {
+		stats.tick()
+		req, err := cfg.newStreamRequest(ctx, "")
+		if err != nil {
+			stats.recordError(err)
+			return
+		}
+		reqStart := time.Now()
+		stats.Stream.Connects++
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				stats.recordError(err)
+			}
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		now := time.Now()
+		switch {
+		case err != nil:
+			if ctx.Err() == nil {
+				stats.recordError(&bodyError{err})
+			}
+		case !cfg.success.ok(resp.StatusCode):
+			stats.recordError(&StatusError{Code: resp.StatusCode})
+		case resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified ||
+			(resp.StatusCode == http.StatusOK && len(bytes.TrimSpace(body)) == 0):
+			// the poll timed out on the server without a notification
+			stats.Stream.EmptyPolls++
+		default:
+			cfg.recordEvent(stats, body, now.Sub(reqStart), now)
+		}
+	}
+}
+
+// recordEvent counts a received event. latency is the time since the previous event (or the request)
+func (cfg *LoadCfg) recordEvent(stats *RequesterStats, data []byte, latency time.Duration, now time.Time) {
+	stats.tick()
+	stats.Stream.Events++
+	stats.NumRequests++
+	stats.TotRespSize += int64(len(data))
+	stats.recordLatency(latency)
+	if delay, ok := cfg.stream.deliveryDelay(data, now); ok {
+		stats.Stream.DeliveryHist.RecordValue(delay.Microseconds())
+	}
+}
@@ -0,0 +1,206 @@
+package loader
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewStreamCfg(t *testing.T) {
+	if _, err := NewStreamCfg("sse", ""); err != nil {
+		t.Errorf("sse: err = %v", err)
+	}
+	if _, err := NewStreamCfg("longpoll", "ts"); err != nil {
+		t.Errorf("longpoll: err = %v", err)
+	}
+	if _, err := NewStreamCfg("websocket", ""); err == nil {
+		t.Error("want err for unknown mode, got nil")
+	}
+}
+
+func TestSSEReader(t *testing.T) {
+	stream := ": heartbeat\n\n" +
+		"id: 1\nevent: update\ndata: line1\ndata: line2\n\n" +
+		"retry: 250\r\ndata:no-space\r\n\r\n" +
+		"id: 3\n\n" + // no data, not dispatched
+		"data: incomplete"
+	rd := &sseReader{r: bufio.NewReader(strings.NewReader(stream))}
+
+	ev, err := rd.next()
+	if err != nil {
+		t.Fatalf("next err = %v", err)
+	}
+	if ev.id != "1" || ev.event != "update" || ev.data != "line1\nline2" {
+		t.Errorf("first event = %+v", ev)
+	}
+
+	ev, err = rd.next()
+	if err != nil {
+		t.Fatalf("next err = %v", err)
+	}
+	if ev.data != "no-space" || ev.retry != 250*time.Millisecond {
+		t.Errorf("second event = %+v", ev)
+	}
+
+	if ev, err = rd.next(); err != io.EOF {
+		t.Errorf("next = %+v, %v, want io.EOF", ev, err)
+	}
+}
+
+func TestStreamCfg_DeliveryDelay(t *testing.T) {
+	s, _ := NewStreamCfg(STREAM_SSE, "ts")
+	now := time.Now()
+
+	d, ok := s.deliveryDelay([]byte(fmt.Sprintf(`{"ts": %d}`, now.Add(-time.Second).UnixMilli())), now)
+	if !ok || d < 999*time.Millisecond || d > 1001*time.Millisecond {
+		t.Errorf("unix ms: delay = %v, %v", d, ok)
+	}
+	d, ok = s.deliveryDelay([]byte(`{"ts": "`+now.Add(-2*time.Second).Format(time.RFC3339Nano)+`"}`), now)
+	if !ok || d != 2*time.Second {
+		t.Errorf("RFC 3339: delay = %v, %v", d, ok)
+	}
+	if _, ok = s.deliveryDelay([]byte(`{"other": 1}`), now); ok {
+		t.Error("missing field: ok = true")
+	}
+	if _, ok = s.deliveryDelay([]byte(`not json`), now); ok {
+		t.Error("not JSON: ok = true")
+	}
+}
+
+func TestRunSingleLoadSession_SSEReconnects(t *testing.T) {
+	var mu sync.Mutex
+	var lastIds []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "text/event-stream" {
+			t.Errorf("Accept = %q", r.Header.Get("Accept"))
+		}
+		mu.Lock()
+		lastIds = append(lastIds, r.Header.Get("Last-Event-ID"))
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/event-stream")
+		// three events, then the server ends the stream
+		fmt.Fprint(w, "retry: 10\n\n")
+		for i := 1; i <= 3; i++ {
+			fmt.Fprintf(w, "id: %d\ndata: {\"ts\": %d}\n\n", i, time.Now().UnixMilli())
+			w.(http.Flusher).Flush()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}))
+	t.Cleanup(ts.Close)
+
+	s, _ := NewStreamCfg(STREAM_SSE, "ts")
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false, WithStream(s))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumErrs != 0 {
+		t.Fatalf("NumErrs = %d, Errors = %v", stats.NumErrs, stats.Errors)
+	}
+	if stats.Stream.Reconnects == 0 || stats.Stream.Connects < 2 {
+		t.Fatalf("Connects = %d, Reconnects = %d, want reconnects", stats.Stream.Connects, stats.Stream.Reconnects)
+	}
+	if stats.Stream.Events != stats.NumRequests || stats.Stream.Events < 3*(stats.Stream.Connects-1) {
+		t.Errorf("Events = %d, NumRequests = %d, Connects = %d", stats.Stream.Events, stats.NumRequests, stats.Stream.Connects)
+	}
+	if got := stats.Stream.FirstEventHist.TotalCount(); got == 0 {
+		t.Error("FirstEventHist is empty")
+	}
+	if got := stats.Stream.GapHist.TotalCount(); got == 0 {
+		t.Error("GapHist is empty")
+	}
+	if got := stats.Stream.DeliveryHist.TotalCount(); got != int64(stats.Stream.Events) {
+		t.Errorf("DeliveryHist count = %d, want %d", got, stats.Stream.Events)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if lastIds[0] != "" || lastIds[1] != "3" {
+		t.Errorf("Last-Event-ID headers = %v, want none then 3", lastIds[:2])
+	}
+}
+
+func TestRunSingleLoadSession_LongPoll(t *testing.T) {
+	var mu sync.Mutex
+	n := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		n++
+		empty := n%2 == 0
+		mu.Unlock()
+		// longer than the -T timeout, which does not apply to streams
+		time.Sleep(60 * time.Millisecond)
+		if empty {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		fmt.Fprintf(w, `{"msg":"hi"}`)
+	}))
+	t.Cleanup(ts.Close)
+
+	s, _ := NewStreamCfg(STREAM_LONGPOLL, "")
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 20, true, false, false, false, "", "", "", false, WithStream(s))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumErrs != 0 {
+		t.Fatalf("NumErrs = %d, Errors = %v", stats.NumErrs, stats.Errors)
+	}
+	if stats.Stream.Events == 0 || stats.Stream.EmptyPolls == 0 {
+		t.Errorf("Events = %d, EmptyPolls = %d, want both > 0", stats.Stream.Events, stats.Stream.EmptyPolls)
+	}
+	if min := stats.Histogram.Min(); min < 60000 {
+		t.Errorf("fastest event = %vus, want at least the 60ms poll", min)
+	}
+}
+
+func TestSSEDelay(t *testing.T) {
+	for _, tc := range []struct {
+		retry    time.Duration
+		failures int
+		want     time.Duration
+	}{
+		{SSE_RETRY, 0, SSE_RETRY},
+		{SSE_RETRY, 1, SSE_RETRY},
+		{SSE_RETRY, 2, 2 * SSE_RETRY},
+		{SSE_RETRY, 3, 4 * SSE_RETRY},
+		{SSE_RETRY, 100, SSE_MAX_RETRY},
+		{10 * time.Millisecond, 0, 10 * time.Millisecond},
+		{2 * SSE_MAX_RETRY, 0, SSE_MAX_RETRY},
+	} {
+		if got := sseDelay(tc.retry, tc.failures); got != tc.want {
+			t.Errorf("sseDelay(%v, %d) = %v, want %v", tc.retry, tc.failures, got, tc.want)
+		}
+	}
+}
+
+func TestRunSingleLoadSession_SSEFailures(t *testing.T) {
+	var requests atomic.Int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(ts.Close)
+
+	s, _ := NewStreamCfg(STREAM_SSE, "")
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false, WithStream(s))
+	start := time.Now()
+	stats := runSession(t, cfg, ch)
+
+	// the first connection fails, the reconnection waits longer than the test
+	if n := requests.Load(); n != 1 || stats.NumErrs != 1 || errorCount(stats, ERR_STATUS) != 1 {
+		t.Errorf("%d requests, NumErrs = %d, Errors = %v, want a single failed connection", n, stats.NumErrs, stats.Errors)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("the session took %v, want it to end with the test", d)
+	}
+}
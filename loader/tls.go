@@ -0,0 +1,189 @@
+package loader
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{"1.0": tls.VersionTLS10, "1.1": tls.VersionTLS11, "1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+var tlsCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521, tls.X25519MLKEM768}
+
+// TLSCfg TLS settings beyond verification and client certificates
+type TLSCfg struct {
+	minVersion uint16
+	maxVersion uint16
+	ciphers    []uint16
+	curves     []tls.CurveID
+	serverName string
+	alpn       []string
+	sessions   tls.ClientSessionCache // shared by all the connections, nil when resumption is off
+	keyLog     io.Writer
+}
+
+// TLSStats the parameters negotiated by the TLS handshakes of the new connections
+type TLSStats struct {
+	Handshakes int
+	Resumed    int
+	Versions   map[string]int
+	Ciphers    map[string]int
+	ALPN       map[string]int // "none" when no protocol was negotiated
+}
+
+// NewTLSCfg minVersion and maxVersion are 1.0 to 1.3, ciphers (TLS 1.2 and below), curves and alpn are comma
+// separated names. Empty values keep the defaults. resume enables session resumption across all the connections.
+// The session keys are appended to keyLogFile when it is given, in the SSLKEYLOGFILE format.
+func NewTLSCfg(minVersion, maxVersion, ciphers, curves, serverName, alpn string, resume bool, keyLogFile string) (*TLSCfg, error) {
+	t := &TLSCfg{serverName: serverName}
+	var err error
+	if t.minVersion, err = parseTLSVersion(minVersion); err != nil {
+		return nil, err
+	}
+	if t.maxVersion, err = parseTLSVersion(maxVersion); err != nil {
+		return nil, err
+	}
+	if t.minVersion != 0 && t.maxVersion != 0 && t.minVersion > t.maxVersion {
+		return nil, fmt.Errorf("TLS min version %v is above the max version %v", minVersion, maxVersion)
+	}
+	for _, name := range splitList(ciphers) {
+		id, ok := cipherSuiteId(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		t.ciphers = append(t.ciphers, id)
+	}
+	for _, name := range splitList(curves) {
+		id, ok := curveId(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown curve %q", name)
+		}
+		t.curves = append(t.curves, id)
+	}
+	t.alpn = splitList(alpn)
+	if resume {
+		t.sessions = tls.NewLRUClientSessionCache(0)
+	}
+	if keyLogFile != "" {
+		if t.keyLog, err = os.OpenFile(keyLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600); err != nil {
+			return nil, fmt.Errorf("could not open the TLS key log file: %v", err)
+		}
+	}
+	return t, nil
+}
+
+// WithTLS applies TLS settings to every TLS connection
+func WithTLS(t *TLSCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.tls = t
+	}
+}
+
+func withTLS(t *TLSCfg) clientOption {
+	return func(o *clientOpts) {
+		o.tls = t
+	}
+}
+
+func splitList(list string) (items []string) {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+func parseTLSVersion(v string) (uint16, error) {
+	if v == "" {
+		return 0, nil
+	}
+	version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(v), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q, expected 1.0, 1.1, 1.2 or 1.3", v)
+	}
+	return version, nil
+}
+
+func cipherSuiteId(name string) (uint16, bool) {
+	for _, suites := range [][]*tls.CipherSuite{tls.CipherSuites(), tls.InsecureCipherSuites()} {
+		for _, s := range suites {
+			if strings.EqualFold(s.Name, name) {
+				return s.ID, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// curveId accepts the Go names (CurveP256) and the usual ones (P-256)
+func curveId(name string) (tls.CurveID, bool) {
+	normalize := func(n string) string {
+		return strings.TrimPrefix(strings.ReplaceAll(strings.ToLower(n), "-", ""), "curve")
+	}
+	for _, c := range tlsCurves {
+		if normalize(c.String()) == normalize(name) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// apply sets the configured values on a tls configuration
+func (t *TLSCfg) apply(c *tls.Config) {
+	c.MinVersion = t.minVersion
+	c.MaxVersion = t.maxVersion
+	c.CipherSuites = t.ciphers
+	c.CurvePreferences = t.curves
+	if t.serverName != "" {
+		c.ServerName = t.serverName
+	}
+	if len(t.alpn) > 0 {
+		c.NextProtos = t.alpn
+	}
+	c.ClientSessionCache = t.sessions
+	c.SessionTicketsDisabled = t.sessions == nil
+	c.KeyLogWriter = t.keyLog
+}
+
+func newTLSStats() *TLSStats {
+	return &TLSStats{Versions: make(map[string]int), Ciphers: make(map[string]int), ALPN: make(map[string]int)}
+}
+
+// record counts the parameters of a new connection
+func (s *TLSStats) record(state *tls.ConnectionState) {
+	s.Handshakes++
+	if state.DidResume {
+		s.Resumed++
+	}
+	s.Versions[tls.VersionName(state.Version)]++
+	s.Ciphers[tls.CipherSuiteName(state.CipherSuite)]++
+	alpn := state.NegotiatedProtocol
+	if alpn == "" {
+		alpn = "none"
+	}
+	s.ALPN[alpn]++
+}
+
+func (stats *RequesterStats) recordTLS(state *tls.ConnectionState) {
+	if stats.TLS == nil {
+		stats.TLS = newTLSStats()
+	}
+	stats.TLS.record(state)
+}
+
+func (s *TLSStats) merge(o *TLSStats) {
+	s.Handshakes += o.Handshakes
+	s.Resumed += o.Resumed
+	for k, v := range o.Versions {
+		s.Versions[k] += v
+	}
+	for k, v := range o.Ciphers {
+		s.Ciphers[k] += v
+	}
+	for k, v := range o.ALPN {
+		s.ALPN[k] += v
+	}
+}
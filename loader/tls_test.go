@@ -0,0 +1,138 @@
+package loader
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewTLSCfg(t *testing.T) {
+	c, err := NewTLSCfg("1.2", "tls1.3", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "x25519, P-256", "api", "h2,http/1.1", true, "")
+	if err != nil {
+		t.Fatalf("NewTLSCfg err = %v", err)
+	}
+	var tc tls.Config
+	c.apply(&tc)
+	if tc.MinVersion != tls.VersionTLS12 || tc.MaxVersion != tls.VersionTLS13 {
+		t.Errorf("versions = %x-%x", tc.MinVersion, tc.MaxVersion)
+	}
+	if len(tc.CipherSuites) != 1 || tc.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Errorf("CipherSuites = %v", tc.CipherSuites)
+	}
+	if len(tc.CurvePreferences) != 2 || tc.CurvePreferences[0] != tls.X25519 || tc.CurvePreferences[1] != tls.CurveP256 {
+		t.Errorf("CurvePreferences = %v", tc.CurvePreferences)
+	}
+	if tc.ServerName != "api" || len(tc.NextProtos) != 2 || tc.ClientSessionCache == nil || tc.SessionTicketsDisabled {
+		t.Errorf("ServerName = %q, NextProtos = %v, ClientSessionCache = %v", tc.ServerName, tc.NextProtos, tc.ClientSessionCache)
+	}
+
+	bad := [][]string{{"1.4", "", "", ""}, {"1.3", "1.2", "", ""}, {"", "", "TLS_NOPE", ""}, {"", "", "", "P-999"}}
+	for _, b := range bad {
+		if _, err = NewTLSCfg(b[0], b[1], b[2], b[3], "", "", false, ""); err == nil {
+			t.Errorf("NewTLSCfg(%q) want err, got nil", b)
+		}
+	}
+}
+
+func TestRunSingleLoadSession_TLSParameters(t *testing.T) {
+	var serverNames = make(chan string, 100)
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	ts.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		select {
+		case serverNames <- hello.ServerName:
+		default:
+		}
+		return nil, nil
+	}}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	keyLog := filepath.Join(t.TempDir(), "keys.log")
+	c, err := NewTLSCfg("", "1.2", "", "", "backend.example", "", true, keyLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan *RequesterStats, 1)
+	// a connection per request, so the later handshakes resume the first session
+	cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, true, true, "", "", "", false, WithTLS(c))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumRequests < 2 || stats.NumErrs != 0 {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+	s := stats.TLS
+	if s == nil || s.Handshakes != stats.NumRequests {
+		t.Fatalf("TLS = %+v, want a handshake per request", s)
+	}
+	if s.Resumed == 0 || s.Resumed == s.Handshakes {
+		t.Errorf("Resumed = %d of %d, want all but the first", s.Resumed, s.Handshakes)
+	}
+	if s.Versions["TLS 1.2"] != s.Handshakes || s.ALPN["none"] != s.Handshakes {
+		t.Errorf("Versions = %v, ALPN = %v", s.Versions, s.ALPN)
+	}
+	if name := <-serverNames; name != "backend.example" {
+		t.Errorf("SNI = %q, want backend.example", name)
+	}
+	if data, _ := os.ReadFile(keyLog); len(data) == 0 {
+		t.Error("the key log file is empty")
+	}
+}
+
+func TestRunSingleLoadSession_ALPN(t *testing.T) {
+	var mu sync.Mutex
+	var offered []string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	ts.EnableHTTP2 = true
+	ts.TLS = &tls.Config{GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		offered = hello.SupportedProtos
+		return nil, nil
+	}}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	for _, tc := range []struct {
+		alpn  string
+		proto string
+	}{
+		{"http/1.1", "HTTP/1.1"},
+		{"http/1.1,h2", ""},
+		{"h2", "HTTP/2.0"},
+		{"", "HTTP/2.0"},
+	} {
+		c, err := NewTLSCfg("", "", "", "", "", tc.alpn, false, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, true, true, "", "", "", true,
+			WithTLS(c))
+
+		stats := runSession(t, cfg, ch)
+
+		if stats.NumRequests == 0 || stats.NumErrs != 0 {
+			t.Fatalf("%q: NumRequests = %d, NumErrs = %d, Errors = %v", tc.alpn, stats.NumRequests, stats.NumErrs,
+				stats.Errors)
+		}
+		mu.Lock()
+		got := strings.Join(offered, ",")
+		mu.Unlock()
+		if want := tc.alpn; want != "" && got != want {
+			t.Errorf("offered %q, want %q", got, want)
+		}
+		if tc.proto != "" && stats.Protocols[tc.proto] != stats.NumRequests {
+			t.Errorf("%q: Protocols = %v, want %v", tc.alpn, stats.Protocols, tc.proto)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package loader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	histo "github.com/HdrHistogram/hdrhistogram-go"
+	"github.com/tsliwowicz/go-wrk/util"
+	"golang.org/x/net/websocket"
+)
+
+const DEFAULT_WS_MESSAGE = "{{.ID}}"
+
+var wsIdRe = regexp.MustCompile(`wrk[0-9a-f]{16}`)
+
+// WSCfg a WebSocket workload. Every goroutine keeps a connection open (reconnecting when it drops) and sends messages
+// on it. Replies are matched to the messages by their correlation id when the message template uses {{.ID}},
+// otherwise the server is expected to answer every message, in order (e.g. an echo server).
+type WSCfg struct {
+	hello     string
+	message   *template.Template
+	rate      float64
+	origin    string
+	correlate bool
+	seq       int64
+}
+
+// wsMessageVars the data available to the message template
+type wsMessageVars struct {
+	ID  string // unique correlation id
+	Seq int64  // sequence number of the message, unique across all goroutines
+}
+
+// WSStats WebSocket specific statistics. The message round trips are the requests of the common statistics.
+type WSStats struct {
+	Connects     int
+	ConnectErrs  int
+	Disconnects  int // connections that were closed by the server or failed before the end of the test
+	MsgsSent     int
+	MsgsRecv     int
+	Unanswered   int // messages that did not get a reply by the end of the connection
+	ConnectHist  *histo.Histogram
+	LifetimeHist *histo.Histogram
+}
+
+// NewWSCfg parses the message template. hello may be empty, an empty message uses DEFAULT_WS_MESSAGE.
+// A rate of 0 sends each message after the reply to the previous one was received.
+func NewWSCfg(hello, message string, rate float64, origin string) (*WSCfg, error) {
+	if message == "" {
+		message = DEFAULT_WS_MESSAGE
+	}
+	if rate < 0 {
+		return nil, fmt.Errorf("websocket message rate can't be negative")
+	}
+	tmpl, err := template.New("message").Parse(message)
+	if err != nil {
+		return nil, fmt.Errorf("invalid websocket message template: %v", err)
+	}
+	return &WSCfg{hello: hello, message: tmpl, rate: rate, origin: origin, correlate: strings.Contains(message, ".ID")}, nil
+}
+
+// WithWebSocket sets the WebSocket workload used for ws:// and wss:// urls
+func WithWebSocket(w *WSCfg) Option {
+	return func(cfg *LoadCfg) {
+		cfg.ws = w
+	}
+}
+
+// IsWebSocketUrl true for ws:// and wss:// urls
+func IsWebSocketUrl(u string) bool {
+	return strings.HasPrefix(u, "ws://") || strings.HasPrefix(u, "wss://")
+}
+
+func newWSStats(duration int) *WSStats {
+	// lifetimes can be a little longer than the test
+	return &WSStats{ConnectHist: newHistogram(duration), LifetimeHist: newHistogram(2 * duration)}
+}
+
+func (w *WSStats) merge(o *WSStats) {
+	w.Connects += o.Connects
+	w.ConnectErrs += o.ConnectErrs
+	w.Disconnects += o.Disconnects
+	w.MsgsSent += o.MsgsSent
+	w.MsgsRecv += o.MsgsRecv
+	w.Unanswered += o.Unanswered
+	w.ConnectHist.Merge(o.ConnectHist)
+	w.LifetimeHist.Merge(o.LifetimeHist)
+}
+
+// next returns the correlation id and the content of the next message
+func (w *WSCfg) next() (string, []byte, error) {
+	seq := atomic.AddInt64(&w.seq, 1)
+	id := fmt.Sprintf("wrk%016x", seq)
+	var buf bytes.Buffer
+	if err := w.message.Execute(&buf, wsMessageVars{ID: id, Seq: seq}); err != nil {
+		return id, nil, err
+	}
+	return id, buf.Bytes(), nil
+}
+
+func (cfg *LoadCfg) wsDial() (*websocket.Conn, error) {
+	origin := cfg.ws.origin
+	if origin == "" {
+		origin = "http" + strings.TrimPrefix(cfg.testUrl, "ws")
+	}
+	wsCfg, err := websocket.NewConfig(cfg.testUrl, origin)
+	if err != nil {
+		return nil, err
+	}
+	if wsCfg.TlsConfig, err = clientTLSConfig(cfg.skipVerify, cfg.clientCert, cfg.clientKey, cfg.caCert); err != nil {
+		return nil, err
+	}
+	if cfg.tls != nil {
+		cfg.tls.apply(wsCfg.TlsConfig)
+	}
+	for hk, hv := range cfg.header {
+		wsCfg.Header.Add(hk, hv)
+	}
+	wsCfg.Header.Set("User-Agent", USER_AGENT)
+
+	// dialed as the other connections are, so that they are bound, resolved and counted the same way
+	addr := wsCfg.Location.Host
+	if wsCfg.Location.Port() == "" {
+		addr = net.JoinHostPort(wsCfg.Location.Hostname(), map[string]string{"ws": "80", "wss": "443"}[wsCfg.Location.Scheme])
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*time.Duration(cfg.timeoutms))
+	defer cancel()
+	conn, err := cfg.sessionDial()(ctx, "tcp", addr)
+	if err != nil {
+		return nil, &websocket.DialError{Config: wsCfg, Err: err}
+	}
+	if wsCfg.Location.Scheme == "wss" {
+		if wsCfg.TlsConfig.ServerName == "" {
+			wsCfg.TlsConfig.ServerName = wsCfg.Location.Hostname()
+		}
+		tc := tls.Client(conn, wsCfg.TlsConfig)
+		if err = tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, &websocket.DialError{Config: wsCfg, Err: err}
+		}
+		conn = tc
+	}
+	ws, err := websocket.NewClient(wsCfg, conn)
+	if err != nil {
+		conn.Close()
+		return nil, &websocket.DialError{Config: wsCfg, Err: err}
+	}
+	return ws, nil
+}
+
+// runWebSocketSession the RunSingleLoadSession loop for WebSocket targets
+func (cfg *LoadCfg) runWebSocketSession(stats *RequesterStats, start time.Time) {
+	stats.WebSocket = newWSStats(cfg.duration)
+	for !cfg.done(start) {
+		stats.tick()
+		connStart := time.Now()
+		ws, err := cfg.wsDial()
+		if err != nil {
+			stats.recordError(err)
+			stats.WebSocket.ConnectErrs++
+			continue
+		}
+		stats.WebSocket.Connects++
+		stats.WebSocket.ConnectHist.RecordValue(time.Since(connStart).Microseconds())
+
+		if cfg.ws.hello != "" {
+			err = websocket.Message.Send(ws, cfg.ws.hello)
+		}
+		if err == nil && cfg.ws.rate > 0 {
+			err = cfg.wsSendAtRate(ws, stats, start)
+		} else {
+			if err == nil {
+				err = cfg.wsPingPong(ws, stats, start)
+			}
+			ws.Close()
+		}
+
+		stats.WebSocket.LifetimeHist.RecordValue(time.Since(connStart).Microseconds())
+		if err != nil {
+			stats.recordError(err)
+			stats.WebSocket.Disconnects++
+		}
+	}
+	if cfg.ws.rate > 0 {
+		// the session is idle between the paced messages, the rate is the one of the pacing, less what was unanswered
+		stats.TotDuration = time.Duration(float64(stats.WebSocket.MsgsSent) / cfg.ws.rate * float64(time.Second))
+	} else {
+		// each message is sent once the reply to the previous one arrived, so the rate is over the whole session
+		stats.TotDuration = time.Since(start)
+	}
+}
+
+// wsPingPong sends a message and waits for its reply before sending the next one
+func (cfg *LoadCfg) wsPingPong(ws *websocket.Conn, stats *RequesterStats, start time.Time) error {
+	timeout := time.Millisecond * time.Duration(cfg.timeoutms)
+	for !cfg.done(start) {
+		stats.tick()
+		id, msg, err := cfg.ws.next()
+		if err != nil {
+			return err
+		}
+		sent := time.Now()
+		ws.SetWriteDeadline(sent.Add(timeout))
+		if err = websocket.Message.Send(ws, string(msg)); err != nil {
+			return err
+		}
+		stats.WebSocket.MsgsSent++
+
+		for {
+			ws.SetReadDeadline(time.Now().Add(timeout))
+			var reply []byte
+			if err = websocket.Message.Receive(ws, &reply); err != nil {
+				stats.WebSocket.Unanswered++
+				return err
+			}
+			stats.WebSocket.MsgsRecv++
+			stats.TotRespSize += int64(len(reply))
+			if !cfg.ws.correlate || bytes.Contains(reply, []byte(id)) {
+				stats.recordLatency(time.Since(sent))
+				stats.NumRequests++
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// wsSendAtRate sends messages at the configured rate while a reader goroutine matches the replies. It closes the
+// connection, which stops the reader.
+func (cfg *LoadCfg) wsSendAtRate(ws *websocket.Conn, stats *RequesterStats, start time.Time) error {
+	var mu sync.Mutex
+	pending := make(map[string]time.Time) // by correlation id
+	var inOrder []time.Time               // without correlation ids
+
+	readErr := make(chan error, 1)
+	go func() {
+		for {
+			var reply []byte
+			if err := websocket.Message.Receive(ws, &reply); err != nil {
+				readErr <- err
+				return
+			}
+			now := time.Now()
+			mu.Lock()
+			stats.WebSocket.MsgsRecv++
+			stats.TotRespSize += int64(len(reply))
+			sent, ok := time.Time{}, false
+			if cfg.ws.correlate {
+				for _, id := range wsIdRe.FindAll(reply, -1) {
+					if sent, ok = pending[string(id)]; ok {
+						delete(pending, string(id))
+						break
+					}
+				}
+			} else if len(inOrder) > 0 {
+				sent, ok = inOrder[0], true
+				inOrder = inOrder[1:]
+			}
+			if ok {
+				stats.recordLatency(now.Sub(sent))
+				stats.NumRequests++
+			}
+			mu.Unlock()
+		}
+	}()
+
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.ws.rate))
+	defer ticker.Stop()
+	var err error
+	readerDone := false
+	for err == nil && !cfg.done(start) {
+		select {
+		case err = <-readErr:
+			readerDone = true
+			continue
+		case <-ticker.C:
+		}
+		id, msg, nextErr := cfg.ws.next()
+		if nextErr != nil {
+			err = nextErr
+			break
+		}
+		mu.Lock()
+		// under mu, as the reader goroutine records the replies
+		stats.tick()
+		if cfg.ws.correlate {
+			pending[id] = time.Now()
+		} else {
+			inOrder = append(inOrder, time.Now())
+		}
+		stats.WebSocket.MsgsSent++
+		mu.Unlock()
+		ws.SetWriteDeadline(time.Now().Add(time.Millisecond * time.Duration(cfg.timeoutms)))
+		err = websocket.Message.Send(ws, string(msg))
+	}
+
+	if err == nil {
+		// give the last replies a chance to arrive, then stop the reader
+		time.Sleep(util.MinDuration(time.Millisecond*time.Duration(cfg.timeoutms), 100*time.Millisecond))
+	}
+	ws.Close()
+	if !readerDone {
+		<-readErr // the reader fails once the connection is closed
+	}
+
+	mu.Lock()
+	stats.WebSocket.Unanswered += len(pending) + len(inOrder)
+	mu.Unlock()
+	return err
+}
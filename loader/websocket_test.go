@@ -0,0 +1,161 @@
+package loader
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/websocket"
+)
+
+func newWSTestServer(t *testing.T, h websocket.Handler) string {
+	t.Helper()
+	ts := httptest.NewServer(h)
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func echoHandler(ws *websocket.Conn) {
+	for {
+		var msg string
+		if err := websocket.Message.Receive(ws, &msg); err != nil {
+			return
+		}
+		if err := websocket.Message.Send(ws, msg); err != nil {
+			return
+		}
+	}
+}
+
+func TestIsWebSocketUrl(t *testing.T) {
+	for u, want := range map[string]bool{"ws://x/": true, "wss://x/": true, "http://x/": false, "https://ws/": false} {
+		if got := IsWebSocketUrl(u); got != want {
+			t.Errorf("IsWebSocketUrl(%q) = %v, want %v", u, got, want)
+		}
+	}
+}
+
+func TestNewWSCfg(t *testing.T) {
+	w, err := NewWSCfg("", "", 0, "")
+	if err != nil {
+		t.Fatalf("NewWSCfg err = %v", err)
+	}
+	if !w.correlate {
+		t.Error("default message should correlate by id")
+	}
+	id, msg, _ := w.next()
+	if string(msg) != id || !wsIdRe.MatchString(id) {
+		t.Errorf("next() = %q, %q", id, msg)
+	}
+
+	if w, _ = NewWSCfg("", "static", 0, ""); w.correlate {
+		t.Error("message without .ID should not correlate")
+	}
+	if _, err = NewWSCfg("", "{{.ID", 0, ""); err == nil {
+		t.Error("want err for bad template, got nil")
+	}
+	if _, err = NewWSCfg("", "", -1, ""); err == nil {
+		t.Error("want err for negative rate, got nil")
+	}
+}
+
+func TestRunSingleLoadSession_WebSocketPingPong(t *testing.T) {
+	gotHello := make(chan string, 1)
+	u := newWSTestServer(t, func(ws *websocket.Conn) {
+		var hello string
+		_ = websocket.Message.Receive(ws, &hello)
+		select {
+		case gotHello <- hello:
+		default:
+		}
+		echoHandler(ws)
+	})
+
+	w, _ := NewWSCfg("hi", "", 0, "")
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, u, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false, WithWebSocket(w))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.WebSocket == nil {
+		t.Fatal("WebSocket stats = nil")
+	}
+	if stats.NumRequests == 0 || stats.NumErrs != 0 {
+		t.Fatalf("NumRequests = %d, NumErrs = %d, Errors = %v", stats.NumRequests, stats.NumErrs, stats.Errors)
+	}
+	if stats.WebSocket.Connects != 1 || stats.WebSocket.ConnectHist.TotalCount() != 1 {
+		t.Errorf("Connects = %d, want 1", stats.WebSocket.Connects)
+	}
+	if stats.WebSocket.MsgsSent != stats.NumRequests || stats.WebSocket.MsgsRecv != stats.NumRequests {
+		t.Errorf("sent %d, received %d, round trips %d", stats.WebSocket.MsgsSent, stats.WebSocket.MsgsRecv, stats.NumRequests)
+	}
+	if stats.WebSocket.LifetimeHist.TotalCount() != 1 {
+		t.Errorf("LifetimeHist count = %d, want 1", stats.WebSocket.LifetimeHist.TotalCount())
+	}
+	if got := <-gotHello; got != "hi" {
+		t.Errorf("handshake message = %q, want %q", got, "hi")
+	}
+}
+
+func TestRunSingleLoadSession_WebSocketRateCorrelated(t *testing.T) {
+	// replies are wrapped and every other message also triggers an unrelated push
+	u := newWSTestServer(t, func(ws *websocket.Conn) {
+		for i := 0; ; i++ {
+			var msg string
+			if err := websocket.Message.Receive(ws, &msg); err != nil {
+				return
+			}
+			if i%2 == 0 {
+				_ = websocket.Message.Send(ws, `{"type":"notification"}`)
+			}
+			_ = websocket.Message.Send(ws, `{"reply":"`+msg+`"}`)
+		}
+	})
+
+	w, _ := NewWSCfg("", `{"id":"{{.ID}}"}`, 50, "")
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, u, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false, WithWebSocket(w))
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.NumErrs != 0 {
+		t.Fatalf("NumErrs = %d, Errors = %v", stats.NumErrs, stats.Errors)
+	}
+	// 50/sec for 1 second, allow for timer slack
+	if stats.WebSocket.MsgsSent < 30 || stats.WebSocket.MsgsSent > 60 {
+		t.Errorf("MsgsSent = %d, want about 50", stats.WebSocket.MsgsSent)
+	}
+	if stats.NumRequests+stats.WebSocket.Unanswered != stats.WebSocket.MsgsSent {
+		t.Errorf("round trips %d + unanswered %d != sent %d", stats.NumRequests, stats.WebSocket.Unanswered, stats.WebSocket.MsgsSent)
+	}
+	if stats.WebSocket.MsgsRecv <= stats.NumRequests {
+		t.Errorf("MsgsRecv = %d, want the pushes counted too (> %d)", stats.WebSocket.MsgsRecv, stats.NumRequests)
+	}
+	if rate := float64(stats.NumRequests) / stats.TotDuration.Seconds(); rate > 50.01 || rate < 40 {
+		t.Errorf("rate = %.2f, want the configured 50/sec less the unanswered messages", rate)
+	}
+}
+
+func TestRunSingleLoadSession_WebSocketServerCloses(t *testing.T) {
+	u := newWSTestServer(t, func(ws *websocket.Conn) {
+		var msg string
+		_ = websocket.Message.Receive(ws, &msg)
+		_ = websocket.Message.Send(ws, msg)
+		// close after the first message
+	})
+
+	ch := make(chan *RequesterStats, 1)
+	cfg := NewLoadCfg(1, 1, u, "", "GET", "", nil, ch, 1000, true, false, false, false, "", "", "", false)
+
+	stats := runSession(t, cfg, ch)
+
+	if stats.WebSocket.Connects < 2 {
+		t.Errorf("Connects = %d, want reconnects", stats.WebSocket.Connects)
+	}
+	if stats.WebSocket.Disconnects == 0 || stats.NumErrs == 0 {
+		t.Errorf("Disconnects = %d, NumErrs = %d, want > 0", stats.WebSocket.Disconnects, stats.NumErrs)
+	}
+	if stats.NumRequests == 0 {
+		t.Error("NumRequests = 0, want the first message of every connection answered")
+	}
+}
@@ -0,0 +1,64 @@
+package loader
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+// WireStats the bytes written to and read from the connections of a test, shared by its sessions. They include the
+// TLS records, the HTTP/2 frames, compressed bodies and proxy handshakes as they were on the wire.
+type WireStats struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// Sent the bytes written to the connections
+func (w *WireStats) Sent() int64 {
+	return w.sent.Load()
+}
+
+// Received the bytes read from the connections
+func (w *WireStats) Received() int64 {
+	return w.received.Load()
+}
+
+// countingConn counts the bytes of a connection in its WireStats
+type countingConn struct {
+	net.Conn
+	wire *WireStats
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.wire.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.wire.sent.Add(int64(n))
+	return n, err
+}
+
+// NetConn the counted connection
+func (c *countingConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func withWire(w *WireStats) clientOption {
+	return func(o *clientOpts) {
+		o.wire = w
+	}
+}
+
+// wrapDial counts the bytes of the connections made by dial
+func (w *WireStats) wrapDial(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn, wire: w}, nil
+	}
+}
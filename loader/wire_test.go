@@ -0,0 +1,103 @@
+package loader
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// countingListener counts the bytes of the accepted connections, as the server sees them
+type countingListener struct {
+	net.Listener
+	wire WireStats
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: conn, wire: &l.wire}, nil
+}
+
+func TestRunSingleLoadSession_WireBytes(t *testing.T) {
+	body := strings.Repeat("w", 1000)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	})
+
+	for _, tc := range []struct {
+		name    string
+		tls     bool
+		options []Option
+	}{
+		{"net", false, nil},
+		{"tls", true, nil},
+		{"raw", false, []Option{WithRaw(&RawCfg{})}},
+		{"pipeline", false, []Option{WithPipeline(4)}},
+	} {
+		ts := httptest.NewUnstartedServer(handler)
+		l := &countingListener{Listener: ts.Listener}
+		ts.Listener = l
+		if tc.tls {
+			ts.StartTLS()
+		} else {
+			ts.Start()
+		}
+
+		ch := make(chan *RequesterStats, 1)
+		cfg := NewLoadCfg(1, 1, ts.URL, "", "GET", "", nil, ch, 1000, true, false, true, true, "", "", "", false,
+			tc.options...)
+		stats := runSession(t, cfg, ch)
+		ts.Close()
+
+		if stats.NumRequests == 0 || stats.NumErrs != 0 || stats.Wire == nil {
+			t.Fatalf("%v: NumRequests = %d, NumErrs = %d, Errors = %v, Wire = %v", tc.name, stats.NumRequests,
+				stats.NumErrs, stats.Errors, stats.Wire)
+		}
+		w := stats.Wire
+		// the last request of the session may still have been in flight when it ended
+		if w.Sent() < l.wire.Received() || w.Received() > l.wire.Sent() || w.Received() < l.wire.Sent()-10000 {
+			t.Errorf("%v: sent %d and received %d bytes, the server received %d and sent %d", tc.name, w.Sent(),
+				w.Received(), l.wire.Received(), l.wire.Sent())
+		}
+		minReq := int64(stats.NumRequests) * int64(len("GET / HTTP/1.1\r\n\r\n"))
+		if stats.TotRespSize < int64(stats.NumRequests*len(body)) || stats.TotReqSize < minReq {
+			t.Errorf("%v: TotRespSize = %d, TotReqSize = %d for %d requests", tc.name, stats.TotRespSize,
+				stats.TotReqSize, stats.NumRequests)
+		}
+		// the TLS records are only on the wire
+		if tc.tls && w.Received() <= stats.TotRespSize {
+			t.Errorf("%v: received %d bytes on the wire for %d bytes of responses", tc.name, w.Received(),
+				stats.TotRespSize)
+		}
+	}
+}
+
+func TestCountingConn(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	w := &WireStats{}
+	conn := &countingConn{Conn: client, wire: w}
+	done := make(chan struct{})
+	go func() {
+		buf := make([]byte, 16)
+		_, _ = server.Read(buf)
+		_, _ = server.Write([]byte("pong!"))
+		close(done)
+	}()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	if _, err := conn.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	conn.Close()
+	if w.Sent() != 4 || w.Received() != 5 {
+		t.Errorf("Sent() = %d, Received() = %d, want 4 and 5", w.Sent(), w.Received())
+	}
+}
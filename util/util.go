@@ -11,18 +11,17 @@ type HeaderList []string
 
 func (i *HeaderList) String() string {
 	out := []string{}
-    for _, s := range *i {
-        out = append(out, s)
-    }
-    return strings.Join(out, ", ")
+	for _, s := range *i {
+		out = append(out, s)
+	}
+	return strings.Join(out, ", ")
 }
 
 func (i *HeaderList) Set(value string) error {
-    *i = append(*i, value)
-    return nil
+	*i = append(*i, value)
+	return nil
 }
 
-
 // RedirectError specific error type that happens on redirection
 type RedirectError struct {
 	msg string
@@ -87,14 +86,14 @@ func MinDuration(d1 time.Duration, d2 time.Duration) time.Duration {
 	}
 }
 
-//EstimateHttpHeadersSize had to create this because headers size was not counted
+// EstimateHttpHeadersSize had to create this because headers size was not counted. Each value is a line of its own, as
+// net/http writes them.
 func EstimateHttpHeadersSize(headers http.Header) (result int64) {
 	result = 0
 
 	for k, v := range headers {
-		result += int64(len(k) + len(": \r\n"))
 		for _, s := range v {
-			result += int64(len(s))
+			result += int64(len(k) + len(": \r\n") + len(s))
 		}
 	}
 
@@ -102,3 +101,19 @@ func EstimateHttpHeadersSize(headers http.Header) (result int64) {
 
 	return result
 }
+
+// EstimateHttpResponseHeadSize the size of the status line and headers of a response
+func EstimateHttpResponseHeadSize(resp *http.Response) int64 {
+	// e.g. HTTP/1.1 200 OK, Status holds the code and the reason
+	return int64(len(resp.Proto)+len(" ")+len(resp.Status)+len("\r\n")) + EstimateHttpHeadersSize(resp.Header)
+}
+
+// EstimateHttpRequestHeadSize the size of the request line, Host and headers of a request
+func EstimateHttpRequestHeadSize(req *http.Request) int64 {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	return int64(len(req.Method)+len(" ")+len(req.URL.RequestURI())+len(" HTTP/1.1\r\n")+len("Host: \r\n")+len(host)) +
+		EstimateHttpHeadersSize(req.Header)
+}
@@ -107,7 +107,8 @@ func TestEstimateHttpHeadersSize(t *testing.T) {
 		h := http.Header{}
 		h.Add("X-Foo", "a")
 		h.Add("X-Foo", "bb")
-		want := int64(len("X-Foo") + len(": \r\n") + len("a") + len("bb") + len("\r\n"))
+		// a line per value
+		want := int64(2*(len("X-Foo")+len(": \r\n")) + len("a") + len("bb") + len("\r\n"))
 		if got := EstimateHttpHeadersSize(h); got != want {
 			t.Fatalf("size = %d, want %d", got, want)
 		}
@@ -130,6 +131,24 @@ func TestEstimateHttpHeadersSize(t *testing.T) {
 	})
 }
 
+func TestEstimateHttpHeadSizes(t *testing.T) {
+	resp := &http.Response{Proto: "HTTP/1.1", Status: "200 OK", Header: http.Header{"X-Foo": {"bar"}}}
+	want := int64(len("HTTP/1.1 200 OK\r\nX-Foo: bar\r\n\r\n"))
+	if got := EstimateHttpResponseHeadSize(resp); got != want {
+		t.Errorf("response head size = %d, want %d", got, want)
+	}
+
+	req, err := http.NewRequest("GET", "http://example.com/a?b=c", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Foo", "bar")
+	want = int64(len("GET /a?b=c HTTP/1.1\r\nHost: example.com\r\nX-Foo: bar\r\n\r\n"))
+	if got := EstimateHttpRequestHeadSize(req); got != want {
+		t.Errorf("request head size = %d, want %d", got, want)
+	}
+}
+
 func TestRedirectError(t *testing.T) {
 	const msg = "no redirects please"
 	err := NewRedirectError(msg)